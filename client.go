@@ -1,6 +1,7 @@
 package pkg
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -117,21 +118,57 @@ func (c *Client) getConn(protocol, host string, options *Options) (Conn, error)
 		conn2 Conn
 	)
 
-	if options.Proxy != "" {
-		conn2, err = c.dialer.DialWithProxy(protocol, host, c.Options.Proxy, c.Options.ProxyDialTimeout, options)
-	} else {
-		//conn2, err = c.dialer.Dial(protocol, host, options)
-		if options.Timeout > 0 {
-			conn2, err = c.dialer.DialTimeout(protocol, host, options.Timeout/2, options)
-		} else {
-			conn2, err = c.dialer.Dial(protocol, host, options)
+	if options.Network != "" || options.Address != "" {
+		// overridden targets are dialed directly
+		conn2, err = c.dialDirect(protocol, host, options)
+	} else if options.ProxyPool != nil {
+		conn2, err = c.dialWithProxyPool(protocol, host, options)
+	} else if len(options.ProxyChain) > 0 {
+		chainDialer, ok := c.dialer.(ProxyChainDialer)
+		if !ok {
+			return nil, errNoProxyChain
 		}
+		conn2, err = chainDialer.DialWithProxyChain(protocol, host, options.ProxyChain, options.ProxyDialTimeout, options)
+	} else if options.Proxy != "" {
+		conn2, err = c.dialer.DialWithProxy(protocol, host, options.Proxy, options.ProxyDialTimeout, options)
+	} else {
+		conn2, err = c.dialDirect(protocol, host, options)
 	}
 
 	return conn2, err
 }
 
+// dialDirect dials host without proxies
+func (c *Client) dialDirect(protocol, host string, options *Options) (Conn, error) {
+	if options.Timeout > 0 {
+		return c.dialer.DialTimeout(protocol, host, options.Timeout/2, options)
+	}
+	return c.dialer.Dial(protocol, host, options)
+}
+
+// dialWithProxyPool dials through a proxy picked from the pool, quarantining it on failure
+func (c *Client) dialWithProxyPool(protocol, host string, options *Options) (Conn, error) {
+	proxyURL, err := options.ProxyPool.Pick(host)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := c.dialer.DialWithProxy(protocol, host, proxyURL, options.ProxyDialTimeout, options)
+	if errors.Is(err, errProxy) {
+		options.ProxyPool.Quarantine(proxyURL)
+	}
+	return conn, err
+}
+
+// CreateConnection connects to the host of url, or to the unix socket of
+// http+unix and https+unix urls, such as http+unix://%2Fvar%2Frun%2Fdocker.sock/
 func (c *Client) CreateConnection(url string, options *Options) (Conn, error) {
+	url, socket := unixURL(url)
+	if socket != "" {
+		unixOptions := *options
+		unixOptions.Network, unixOptions.Address = "unix", socket
+		options = &unixOptions
+	}
+
 	protocol := "http"
 	if strings.HasPrefix(strings.ToLower(url), "https://") {
 		protocol = "https"
@@ -185,6 +222,53 @@ func (c *Client) CreateConnection(url string, options *Options) (Conn, error) {
 func (c *Client) do(getConn Conn, method, url, uripath string, headers map[string][]string,
 	body io.Reader, rawBuffer []byte, redirectStatus *RedirectStatus, options *Options) (*client.Request, *http.Response, error) {
 
+	req, protocol, host, err := buildRequest(method, url, uripath, headers, body, rawBuffer, options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err2 := getConn.WriteRequest(req); err2 != nil {
+		return req, nil, err2
+	}
+	resp, err2 := getConn.ReadResponse(options.ForceReadAllBody)
+	if err2 != nil {
+		return req, nil, err2
+	}
+
+	r, err := toHTTPResponse(resp, getConn, &responseMeta{proxy: connProxy(getConn)}, newHTTPRequest(method, url, headers))
+	if err != nil {
+		return req, nil, err
+	}
+
+	if resp.Status.IsRedirect() && redirectStatus.FollowRedirects && redirectStatus.Current <= redirectStatus.MaxRedirects {
+		// consume the response body
+		_, err3 := io.Copy(io.Discard, r.Body)
+		if err4 := firstErr(err3, r.Body.Close()); err4 != nil {
+			return req, nil, err4
+		}
+		loc := headerValue(r.Header, "Location")
+		if strings.HasPrefix(loc, "/") {
+			loc = fmt.Sprintf("%s://%s%s", protocol, host, loc)
+		}
+		redirectStatus.Current++
+		return c.do(getConn, method, loc, uripath, headers, body, rawBuffer, redirectStatus, options)
+	}
+
+	return req, r, err
+}
+
+// RedirectStatus is the current redirect status for the request
+type RedirectStatus struct {
+	FollowRedirects bool
+	MaxRedirects    int
+	Current         int
+}
+
+// buildRequest builds the request for url, returning it along with the protocol
+// and the host:port it targets
+func buildRequest(method, url, uripath string, headers map[string][]string, body io.Reader,
+	rawBuffer []byte, options *Options) (*client.Request, string, string, error) {
+	url, _ = unixURL(url)
 	protocol := "http"
 	if strings.HasPrefix(strings.ToLower(url), "https://") {
 		protocol = "https"
@@ -195,7 +279,7 @@ func (c *Client) do(getConn Conn, method, url, uripath string, headers map[strin
 	}
 	u, err := urlutil.ParseURL(url, true)
 	if err != nil {
-		return nil, nil, err
+		return nil, "", "", err
 	}
 
 	host := u.Host
@@ -229,54 +313,12 @@ func (c *Client) do(getConn Conn, method, url, uripath string, headers map[strin
 		protocol = "https"
 	}
 
-	//getConn, err := c.getConn(protocol, host, options)
-	//if err != nil {
-	//	return nil, nil, nil, err
-	//}
-	//
-	//// set timeout if any
-	//if options.Timeout > 0 {
-	//	getConn.SetTimeout(options.Timeout)
-	//}
-
 	req := toRequest(method, u.Host, path, nil, headers, body, rawBuffer, options)
 	req.AutomaticContentLength = options.AutomaticContentLength
 	req.AutomaticHost = options.AutomaticHostHeader
-
-	if err2 := getConn.WriteRequest(req); err2 != nil {
-		return req, nil, err2
-	}
-	resp, err2 := getConn.ReadResponse(options.ForceReadAllBody)
-	if err2 != nil {
-		return req, nil, err2
-	}
-
-	r, err := toHTTPResponse(getConn, resp)
-	if err != nil {
-		return req, nil, err
+	if isForwardProxy(protocol, options) {
+		toForwardProxyRequest(req, protocol, u.Host, options)
 	}
 
-	if resp.Status.IsRedirect() && redirectStatus.FollowRedirects && redirectStatus.Current <= redirectStatus.MaxRedirects {
-		//fmt.Println(redirectStatus.FollowRedirects)
-		// consume the response body
-		_, err3 := io.Copy(io.Discard, r.Body)
-		if err4 := firstErr(err3, r.Body.Close()); err4 != nil {
-			return req, nil, err4
-		}
-		loc := headerValue(r.Header, "Location")
-		if strings.HasPrefix(loc, "/") {
-			loc = fmt.Sprintf("%s://%s%s", protocol, host, loc)
-		}
-		redirectStatus.Current++
-		return c.do(getConn, method, loc, uripath, headers, body, rawBuffer, redirectStatus, options)
-	}
-
-	return req, r, err
-}
-
-// RedirectStatus is the current redirect status for the request
-type RedirectStatus struct {
-	FollowRedirects bool
-	MaxRedirects    int
-	Current         int
+	return req, protocol, host, nil
 }
@@ -0,0 +1,363 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseMode selects how responses breaking the grammar of rfc 9112 are handled.
+type ParseMode int
+
+const (
+	// Lenient accepts malformed responses, recording what was wrong as anomalies.
+	Lenient ParseMode = iota
+	// Strict rejects a response at its first anomaly.
+	Strict
+)
+
+// AnomalyKind is a deviation from the response grammar seen by the parser.
+type AnomalyKind int
+
+const (
+	// AnomalyBareLF is a line ending with LF instead of CRLF.
+	AnomalyBareLF AnomalyKind = iota + 1
+	// AnomalyNUL is a NUL byte in the status line or a header line.
+	AnomalyNUL
+	// AnomalyInvalidVersion is an HTTP version whose digits are not digits.
+	AnomalyInvalidVersion
+	// AnomalyStatusCodeLength is a status code not 3 digits long.
+	AnomalyStatusCodeLength
+	// AnomalyWhitespaceBeforeColon is whitespace between a header name and its colon.
+	AnomalyWhitespaceBeforeColon
+	// AnomalyInvalidHeaderName is a header name that is not a token.
+	AnomalyInvalidHeaderName
+	// AnomalyObsFold is a header value continued on the next line.
+	AnomalyObsFold
+	// AnomalyConflictingContentLength is Content-Length values disagreeing.
+	AnomalyConflictingContentLength
+	// AnomalyContentLengthWithTransferEncoding is a Content-Length sent along
+	// with Transfer-Encoding, the body being framed by Transfer-Encoding.
+	AnomalyContentLengthWithTransferEncoding
+	// AnomalyHTTP09 is a response without status line read as HTTP/0.9.
+	AnomalyHTTP09
+	// AnomalyTransferEncoding is a Transfer-Encoding other than a single
+	// "chunked": other codings, chunked not last, another case or several fields.
+	AnomalyTransferEncoding
+)
+
+func (k AnomalyKind) String() string {
+	switch k {
+	case AnomalyBareLF:
+		return "bare LF line ending"
+	case AnomalyNUL:
+		return "NUL byte"
+	case AnomalyInvalidVersion:
+		return "invalid version"
+	case AnomalyStatusCodeLength:
+		return "invalid status code length"
+	case AnomalyWhitespaceBeforeColon:
+		return "whitespace before colon"
+	case AnomalyInvalidHeaderName:
+		return "invalid header name"
+	case AnomalyObsFold:
+		return "obsolete line folding"
+	case AnomalyConflictingContentLength:
+		return "conflicting Content-Length"
+	case AnomalyContentLengthWithTransferEncoding:
+		return "Content-Length with Transfer-Encoding"
+	case AnomalyHTTP09:
+		return "HTTP/0.9 response"
+	case AnomalyTransferEncoding:
+		return "unusual Transfer-Encoding"
+	}
+	return fmt.Sprintf("AnomalyKind(%d)", int(k))
+}
+
+// Anomaly is a deviation from the response grammar along with the line it was
+// found on.
+type Anomaly struct {
+	Kind AnomalyKind
+	Line string
+}
+
+// AnomalyError is returned in Strict mode for the first anomaly of a response.
+type AnomalyError struct {
+	Anomaly
+}
+
+func (e *AnomalyError) Error() string {
+	return fmt.Sprintf("malformed response: %s: %q", e.Kind, e.Line)
+}
+
+// anomalies collects the anomalies of a response according to mode, reading
+// it within limits.
+type anomalies struct {
+	mode   ParseMode
+	limits Limits
+	list   []Anomaly
+}
+
+// add records an anomaly, returning the error ending the parsing in Strict mode.
+func (a *anomalies) add(kind AnomalyKind, line []byte) error {
+	anomaly := Anomaly{Kind: kind, Line: string(line)}
+	a.list = append(a.list, anomaly)
+	if a.mode == Strict {
+		return &AnomalyError{anomaly}
+	}
+	return nil
+}
+
+// line checks the line ending and the bytes of line.
+func (a *anomalies) line(line []byte) error {
+	if bytes.HasSuffix(line, []byte("\n")) && !bytes.HasSuffix(line, []byte("\r\n")) {
+		if err := a.add(AnomalyBareLF, line); err != nil {
+			return err
+		}
+	}
+	if bytes.IndexByte(line, 0) >= 0 {
+		return a.add(AnomalyNUL, line)
+	}
+	return nil
+}
+
+// ParseStatusLine parses line, a status line with its line ending. Numeric
+// status codes of any length are accepted.
+func ParseStatusLine(line []byte, mode ParseMode) (Version, int, string, []Anomaly, error) {
+	a := anomalies{mode: mode}
+	version, code, msg, err := a.statusLine(line)
+	return version, code, msg, a.list, err
+}
+
+// ReadStatusLine reads and parses a status line from r.
+func ReadStatusLine(r *bufio.Reader, options ParseOptions) (Version, int, string, []Anomaly, error) {
+	a := anomalies{mode: options.Mode, limits: options.Limits}
+	version, code, msg, err := a.readStatusLine(r)
+	return version, code, msg, a.list, err
+}
+
+func (a *anomalies) readStatusLine(r sliceReader) (Version, int, string, error) {
+	line, err := readLine(r, a.limits.statusLineLength())
+	if err == ErrLineTooLong {
+		return Version{}, 0, "", &LimitError{Kind: LimitStatusLineLength, Limit: a.limits.statusLineLength()}
+	}
+	if err != nil {
+		return Version{}, 0, "", err
+	}
+	return a.statusLine(line)
+}
+
+func (a *anomalies) statusLine(line []byte) (Version, int, string, error) {
+	if err := a.line(line); err != nil {
+		return Version{}, 0, "", err
+	}
+	content := bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+
+	// HTTP/x.x followed by a space
+	const prefix = "HTTP/x.x "
+	for pos := 0; pos < len(prefix); pos++ {
+		if pos >= len(content) {
+			return Version{}, 0, "", fmt.Errorf("ReadVersion: unexpected end of line at position %v", pos)
+		}
+		expected, c := prefix[pos], content[pos]
+		if expected != 'x' && c != expected {
+			_, err := readVersionErr(pos, expected, c)
+			return Version{}, 0, "", err
+		}
+	}
+	var version Version
+	major, minor := content[5], content[7]
+	if isDigit(major) && isDigit(minor) {
+		version = Version{Major: int(major - '0'), Minor: int(minor - '0')}
+	} else if err := a.add(AnomalyInvalidVersion, line); err != nil {
+		return Version{}, 0, "", err
+	}
+
+	// the status code runs until a space or the end of the line
+	codeField, msg, _ := bytes.Cut(content[len(prefix):], []byte(" "))
+	if len(codeField) > maxStatusCodeLength {
+		return Version{}, 0, "", fmt.Errorf("ReadStatusCode: status code too long: %q", codeField)
+	}
+	code, err := strconv.Atoi(string(codeField))
+	if err != nil {
+		return Version{}, 0, "", fmt.Errorf("ReadStatusCode: %w", err)
+	}
+	if len(codeField) != 3 {
+		if err := a.add(AnomalyStatusCodeLength, line); err != nil {
+			return Version{}, 0, "", err
+		}
+	}
+	return version, code, string(msg), nil
+}
+
+// ParseHeaderLine parses line, a header field line with its line ending.
+func ParseHeaderLine(line []byte, mode ParseMode) (string, string, []Anomaly, error) {
+	a := anomalies{mode: mode}
+	key, value, err := a.headerLine(line)
+	return key, value, a.list, err
+}
+
+func (a *anomalies) headerLine(line []byte) (string, string, error) {
+	if err := a.line(line); err != nil {
+		return "", "", err
+	}
+	name, value, ok := bytes.Cut(line, []byte(":"))
+	if !ok {
+		return "", "", fmt.Errorf("invalid header line: %q", line)
+	}
+	key := bytes.TrimSpace(name)
+	if len(key) > 0 && len(key) != len(bytes.TrimLeft(name, " \t")) {
+		if err := a.add(AnomalyWhitespaceBeforeColon, line); err != nil {
+			return "", "", err
+		}
+	}
+	if !isToken(key) {
+		if err := a.add(AnomalyInvalidHeaderName, line); err != nil {
+			return "", "", err
+		}
+	}
+	return string(key), string(bytes.TrimSpace(value)), nil
+}
+
+// ReadHeaders reads the header section up to and including the empty line
+// ending it. In Lenient mode folded lines are joined to the header they
+// continue.
+func ReadHeaders(r *bufio.Reader, options ParseOptions) ([]Header, []Anomaly, error) {
+	a := anomalies{mode: options.Mode, limits: options.Limits}
+	headers, err := a.readHeaders(r)
+	return headers, a.list, err
+}
+
+func (a *anomalies) readHeaders(r sliceReader) ([]Header, error) {
+	var headers []Header
+	size := 0
+	for {
+		line, err := readLine(r, a.limits.headerLineLength())
+		if err == ErrLineTooLong {
+			return headers, &LimitError{Kind: LimitHeaderLineLength, Limit: a.limits.headerLineLength(), Headers: headers}
+		}
+		if err != nil {
+			return headers, err
+		}
+		if size += len(line); size > a.limits.headerBytes() {
+			return headers, &LimitError{Kind: LimitHeaderBytes, Limit: a.limits.headerBytes(), Headers: headers}
+		}
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			if err := a.line(line); err != nil {
+				return headers, err
+			}
+			return headers, nil
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if err := a.add(AnomalyObsFold, line); err != nil {
+				return headers, err
+			}
+			if err := a.line(line); err != nil {
+				return headers, err
+			}
+			// a folded line before any header is dropped, rfc 9112 s2.2
+			if len(headers) > 0 {
+				last := &headers[len(headers)-1]
+				last.Value = strings.TrimSpace(last.Value + " " + string(bytes.TrimSpace(line)))
+			}
+			continue
+		}
+		key, value, err := a.headerLine(line)
+		if err != nil {
+			return headers, err
+		}
+		if key == "" {
+			// empty header values are valid, rfc 2616 s4.2.
+			return headers, fmt.Errorf("invalid header line: %q", line)
+		}
+		if len(headers) == a.limits.headerCount() {
+			return headers, &LimitError{Kind: LimitHeaderCount, Limit: a.limits.headerCount(), Headers: headers}
+		}
+		headers = append(headers, Header{key, value})
+	}
+}
+
+// FramingAnomalies checks the headers framing the body: Content-Length values
+// disagreeing, a Transfer-Encoding other than a single "chunked" and
+// Content-Length sent along with Transfer-Encoding.
+func FramingAnomalies(headers []Header, mode ParseMode) ([]Anomaly, error) {
+	a := anomalies{mode: mode}
+	err := a.framing(headers)
+	return a.list, err
+}
+
+func (a *anomalies) framing(headers []Header) error {
+	var (
+		length           string
+		lengthLine       string
+		conflicting      bool
+		transferEncoding []string // Transfer-Encoding lines
+		unusual          bool     // a Transfer-Encoding value is not "chunked"
+	)
+	for _, h := range headers {
+		switch {
+		case strings.EqualFold(h.Key, "Content-Length"):
+			for _, v := range strings.Split(h.Value, ",") {
+				v = strings.TrimSpace(v)
+				if lengthLine == "" {
+					length, lengthLine = v, h.Key+": "+h.Value
+				} else if v != length {
+					conflicting = true
+				}
+			}
+		case strings.EqualFold(h.Key, "Transfer-Encoding"):
+			transferEncoding = append(transferEncoding, h.Key+": "+h.Value)
+			if h.Value != "chunked" {
+				unusual = true
+			}
+		}
+	}
+	if conflicting {
+		if err := a.add(AnomalyConflictingContentLength, []byte(lengthLine)); err != nil {
+			return err
+		}
+	}
+	if unusual || len(transferEncoding) > 1 {
+		if err := a.add(AnomalyTransferEncoding, []byte(strings.Join(transferEncoding, "\r\n"))); err != nil {
+			return err
+		}
+	}
+	if len(transferEncoding) > 0 && lengthLine != "" {
+		return a.add(AnomalyContentLengthWithTransferEncoding, []byte(lengthLine))
+	}
+	return nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isToken reports whether b is a token, rfc 9110 s5.6.2
+func isToken(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	for _, c := range b {
+		if c >= 0x80 || !tokenChars[c] {
+			return false
+		}
+	}
+	return true
+}
+
+var tokenChars = func() [128]bool {
+	var t [128]bool
+	for c := '0'; c <= '9'; c++ {
+		t[c] = true
+	}
+	for c := 'a'; c <= 'z'; c++ {
+		t[c] = true
+		t[c-'a'+'A'] = true
+	}
+	for _, c := range "!#$%&'*+-.^_`|~" {
+		t[c] = true
+	}
+	return t
+}()
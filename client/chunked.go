@@ -0,0 +1,180 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Chunk is a chunk of a chunked body as it was received.
+type Chunk struct {
+	Size       int64
+	Extensions string // chunk extensions after the size, without the first ';'
+}
+
+// ChunkedReader decodes a chunked body, rfc 9112 s7.1. Unlike
+// httputil.NewChunkedReader it keeps the chunk sizes and extensions, the
+// trailer fields and the raw bytes it consumed, complete once it returned
+// io.EOF. The chunks and raw bytes kept are bounded by Limits.MaxChunkCount
+// and Limits.MaxRawChunkedLength, see Truncated. It never reads past the end
+// of the body.
+type ChunkedReader struct {
+	r         *bufio.Reader
+	options   ParseOptions
+	chunks    []Chunk
+	trailers  []Header
+	anomalies []Anomaly
+	raw       bytes.Buffer
+	truncated bool  // whether chunks or raw bytes were dropped
+	n         int64 // bytes left in the current chunk
+	err       error
+}
+
+// NewChunkedReader returns a ChunkedReader decoding r, its chunk size lines and
+// trailers parsed according to options.
+func NewChunkedReader(r io.Reader, options ParseOptions) *ChunkedReader {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &ChunkedReader{r: br, options: options}
+}
+
+func (cr *ChunkedReader) Read(p []byte) (int, error) {
+	for cr.n == 0 && cr.err == nil {
+		cr.err = cr.nextChunk()
+	}
+	if cr.err != nil {
+		return 0, cr.err
+	}
+	if int64(len(p)) > cr.n {
+		p = p[:cr.n]
+	}
+	n, err := cr.r.Read(p)
+	cr.record(p[:n])
+	cr.n -= int64(n)
+	if cr.n == 0 && err == nil {
+		err = cr.endChunk()
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	cr.err = err
+	return n, err
+}
+
+// Chunks returns the chunks read so far, the last one of size 0 once the body
+// is complete.
+func (cr *ChunkedReader) Chunks() []Chunk {
+	return cr.chunks
+}
+
+// Trailers returns the trailer fields, read after the last chunk.
+func (cr *ChunkedReader) Trailers() []Header {
+	return cr.trailers
+}
+
+// Anomalies returns the anomalies seen in the trailer fields.
+func (cr *ChunkedReader) Anomalies() []Anomaly {
+	return cr.anomalies
+}
+
+// Raw returns the chunked bytes consumed so far, framing included.
+func (cr *ChunkedReader) Raw() []byte {
+	return cr.raw.Bytes()
+}
+
+// Truncated reports whether chunks or raw bytes were dropped past the limits,
+// Chunks and Raw then only holding the start of the body.
+func (cr *ChunkedReader) Truncated() bool {
+	return cr.truncated
+}
+
+// ReadSlice reads from the body up to and including delim, recording the bytes.
+func (cr *ChunkedReader) ReadSlice(delim byte) ([]byte, error) {
+	line, err := cr.r.ReadSlice(delim)
+	cr.record(line)
+	return line, err
+}
+
+// record keeps the raw bytes p up to the limit.
+func (cr *ChunkedReader) record(p []byte) {
+	if room := cr.options.rawChunkedLength() - cr.raw.Len(); len(p) > room {
+		p = p[:room]
+		cr.truncated = true
+	}
+	cr.raw.Write(p)
+}
+
+// nextChunk reads a chunk size line, then the trailers after the last chunk.
+func (cr *ChunkedReader) nextChunk() error {
+	line, err := readLine(cr, cr.options.headerLineLength())
+	if err == ErrLineTooLong {
+		return &LimitError{Kind: LimitHeaderLineLength, Limit: cr.options.headerLineLength()}
+	}
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	sizeField, extensions, _ := bytes.Cut(bytes.TrimRight(line, "\r\n"), []byte(";"))
+	sizeField = bytes.TrimRight(sizeField, " \t")
+	if !isHex(sizeField) {
+		return fmt.Errorf("invalid chunk size line: %q", line)
+	}
+	size, err := strconv.ParseInt(string(sizeField), 16, 64)
+	if err != nil {
+		return fmt.Errorf("invalid chunk size line: %q", line)
+	}
+	if len(cr.chunks) < cr.options.chunkCount() {
+		cr.chunks = append(cr.chunks, Chunk{Size: size, Extensions: string(extensions)})
+	} else {
+		cr.truncated = true
+	}
+	if size > 0 {
+		cr.n = size
+		return nil
+	}
+
+	a := anomalies{mode: cr.options.Mode, limits: cr.options.Limits}
+	cr.trailers, err = a.readHeaders(cr)
+	cr.anomalies = a.list
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	return io.EOF
+}
+
+// endChunk reads the line ending a chunk's data.
+func (cr *ChunkedReader) endChunk() error {
+	line, err := readLine(cr, cr.options.headerLineLength())
+	if err == ErrLineTooLong {
+		return &LimitError{Kind: LimitHeaderLineLength, Limit: cr.options.headerLineLength()}
+	}
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimRight(line, "\r\n")) != 0 {
+		return fmt.Errorf("invalid chunk terminator: %q", line)
+	}
+	return nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func isHex(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	for _, c := range b {
+		if !isDigit(c) && (c < 'a' || c > 'f') && (c < 'A' || c > 'F') {
+			return false
+		}
+	}
+	return true
+}
@@ -3,10 +3,8 @@ package client
 import (
 	"bufio"
 	"bytes"
-	"errors"
 	"fmt"
 	"io"
-	"net/http/httputil"
 	"strconv"
 	"strings"
 )
@@ -22,6 +20,7 @@ func (v *Version) String() string {
 }
 
 var (
+	HTTP_0_9 = Version{Major: 0, Minor: 9}
 	HTTP_1_0 = Version{Major: 1, Minor: 0}
 	HTTP_1_1 = Version{Major: 1, Minor: 1}
 )
@@ -75,15 +74,34 @@ type Client interface {
 
 // NewClient returns a Client implementation which uses rw to communicate.
 func NewClient(rw io.ReadWriter) Client {
+	return NewClientWithMode(rw, Lenient)
+}
+
+// NewClientWithMode returns a Client parsing responses according to mode.
+func NewClientWithMode(rw io.ReadWriter, mode ParseMode) Client {
+	return NewClientWithOptions(rw, ParseOptions{Mode: mode})
+}
+
+// ParseOptions configures how a Client parses responses.
+type ParseOptions struct {
+	Mode           ParseMode
+	HTTP09Fallback bool // reads a non-HTTP response as an HTTP/0.9 one whose body is the raw stream
+	Limits
+}
+
+// NewClientWithOptions returns a Client parsing responses according to options.
+func NewClientWithOptions(rw io.ReadWriter, options ParseOptions) Client {
 	return &client{
-		reader: reader{bufio.NewReaderSize(rw, readerBuffer)},
-		writer: writer{Writer: rw},
+		reader:  reader{bufio.NewReaderSize(rw, readerBuffer)},
+		writer:  writer{Writer: rw},
+		options: options,
 	}
 }
 
 type client struct {
 	reader
 	writer
+	options ParseOptions
 }
 
 // SendRequest marshalls a HTTP request to the wire.
@@ -121,37 +139,42 @@ func (c *client) WriteRequest(req *Request) error {
 	return c.WriteBody(req.Body)
 }
 
-// ReadResponse unmarshalls a HTTP response.
+// ReadResponse unmarshalls a HTTP response. A non-HTTP response fails with a
+// *NonHTTPError unless HTTP09Fallback is set.
 func (c *client) ReadResponse(forceReadAll bool) (*Response, error) {
-	version, code, msg, err := c.ReadStatusLine()
-	var headers []Header
+	a := anomalies{mode: c.options.Mode, limits: c.options.Limits}
+	if http09, err := a.http09(c.Reader, c.options.HTTP09Fallback); err != nil {
+		return nil, err
+	} else if http09 {
+		return &Response{Version: HTTP_0_9, Status: Status{SUCCESS_OK, "OK"}, Body: c.ReadBody(), Anomalies: a.list}, nil
+	}
+	version, code, msg, err := a.readStatusLine(c.Reader)
 	if err != nil {
-		return nil, fmt.Errorf("ReadStatusLine: %v", err)
-	}
-	for {
-		var key, value string
-		var done bool
-		key, value, done, err = c.ReadHeader()
-		if err != nil || done {
-			break
-		}
-		if key == "" {
-			// empty header values are valid, rfc 2616 s4.2.
-			err = errors.New("invalid header")
-			break
-		}
-		headers = append(headers, Header{key, value})
+		return nil, fmt.Errorf("ReadStatusLine: %w", err)
 	}
-	var resp = Response{
-		Version: version,
-		Status:  Status{code, msg},
-		Headers: headers,
-		Body:    c.ReadBody(),
+	headers, err := a.readHeaders(c.Reader)
+	if err == nil {
+		err = a.framing(headers)
 	}
-	if l := resp.ContentLength(); l >= 0 && !forceReadAll {
+	var resp = Response{
+		Version:   version,
+		Status:    Status{code, msg},
+		Headers:   headers,
+		Body:      c.ReadBody(),
+		Stream:    c.Reader,
+		Anomalies: a.list,
+	}
+	// Transfer-Encoding overrides Content-Length, rfc 9112 s6.3
+	switch l := resp.ContentLength(); {
+	case code == INFO_SWITCHING_PROTOCOL:
+		// no body, the rest of the stream is in the protocol switched to
+	case resp.TransferEncoding() == "chunked":
+		resp.Chunked = NewChunkedReader(c.Reader, c.options)
+		resp.Body = resp.Chunked
+	case resp.TransferEncoding() != "identity":
+		// chunked is not the final coding, the body runs until the close
+	case l >= 0 && !forceReadAll:
 		resp.Body = io.LimitReader(resp.Body, l)
-	} else if resp.TransferEncoding() == "chunked" {
-		resp.Body = httputil.NewChunkedReader(resp.Body)
 	}
 	return &resp, err
 }
@@ -160,8 +183,11 @@ func (c *client) ReadResponse(forceReadAll bool) (*Response, error) {
 type Response struct {
 	Version
 	Status
-	Headers []Header
-	Body    io.Reader
+	Headers   []Header
+	Body      io.Reader
+	Anomalies []Anomaly      // deviations from the grammar seen while parsing
+	Chunked   *ChunkedReader // decoder of a chunked body, with its chunks and trailers
+	Stream    io.Reader      // the connection right after the head, for tunnels whatever the framing
 }
 
 // ContentLength returns the length of the body. If the body length is not known
@@ -189,18 +215,35 @@ func (r *Response) CloseRequested() bool {
 	return false
 }
 
-// TransferEncoding returns the transfer encoding this message was transmitted with.
-// If not is specified by the sender, "identity" is assumed.
+// TransferEncoding returns the transfer encoding this message was transmitted with,
+// the last coding applied in lower case. If not is specified by the sender,
+// "identity" is assumed.
 func (r *Response) TransferEncoding() string {
-	for _, h := range r.Headers {
-		if strings.EqualFold(h.Key, "Transfer-Encoding") {
-			switch h.Value {
-			case "identity", "chunked":
-				return h.Value
+	return lastCoding(TransferCodings(r.Headers))
+}
+
+// TransferCodings returns the transfer codings listed by the Transfer-Encoding
+// headers in the order they were applied, in lower case, rfc 9112 s6.1.
+func TransferCodings(headers []Header) []string {
+	var codings []string
+	for _, h := range headers {
+		if !strings.EqualFold(h.Key, "Transfer-Encoding") {
+			continue
+		}
+		for _, v := range strings.Split(h.Value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				codings = append(codings, strings.ToLower(v))
 			}
 		}
 	}
-	return "identity"
+	return codings
+}
+
+func lastCoding(codings []string) string {
+	if len(codings) == 0 {
+		return "identity"
+	}
+	return codings[len(codings)-1]
 }
 
 // Message represents common traits of both Requests and Responses.
@@ -0,0 +1,133 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+)
+
+const (
+	DefaultMaxStatusLineLength = 8 << 10
+	DefaultMaxHeaderLineLength = 64 << 10
+	DefaultMaxHeaderBytes      = 1 << 20
+	DefaultMaxHeaderCount      = 1000
+	DefaultMaxRawChunkedLength = 1 << 20
+	DefaultMaxChunkCount       = 1000
+)
+
+// Limits bound the size of the response head read by the parser. Zero values
+// use the defaults.
+type Limits struct {
+	MaxStatusLineLength int // DefaultMaxStatusLineLength if not set
+	MaxHeaderLineLength int // DefaultMaxHeaderLineLength if not set
+	MaxHeaderBytes      int // total size of a header section, DefaultMaxHeaderBytes if not set
+	MaxHeaderCount      int // DefaultMaxHeaderCount if not set
+	// MaxBodyLength bounds the bodies read whole, by the pipelined client, no
+	// limit if not set
+	MaxBodyLength int
+	// MaxRawChunkedLength bounds the raw bytes of a chunked body kept by
+	// ChunkedReader, DefaultMaxRawChunkedLength if not set. The body is still
+	// decoded past it.
+	MaxRawChunkedLength int
+	// MaxChunkCount bounds the chunks kept by ChunkedReader, DefaultMaxChunkCount
+	// if not set. The body is still decoded past it.
+	MaxChunkCount int
+}
+
+func (l Limits) statusLineLength() int {
+	return orDefault(l.MaxStatusLineLength, DefaultMaxStatusLineLength)
+}
+
+func (l Limits) headerLineLength() int {
+	return orDefault(l.MaxHeaderLineLength, DefaultMaxHeaderLineLength)
+}
+
+func (l Limits) headerBytes() int {
+	return orDefault(l.MaxHeaderBytes, DefaultMaxHeaderBytes)
+}
+
+func (l Limits) headerCount() int {
+	return orDefault(l.MaxHeaderCount, DefaultMaxHeaderCount)
+}
+
+func (l Limits) rawChunkedLength() int {
+	return orDefault(l.MaxRawChunkedLength, DefaultMaxRawChunkedLength)
+}
+
+func (l Limits) chunkCount() int {
+	return orDefault(l.MaxChunkCount, DefaultMaxChunkCount)
+}
+
+func orDefault(v, def int) int {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
+
+// LimitKind is the limit a response exceeded.
+type LimitKind int
+
+const (
+	LimitStatusLineLength LimitKind = iota + 1
+	LimitHeaderLineLength
+	LimitHeaderBytes
+	LimitHeaderCount
+	LimitBodyLength
+)
+
+func (k LimitKind) String() string {
+	switch k {
+	case LimitStatusLineLength:
+		return "status line length"
+	case LimitHeaderLineLength:
+		return "header line length"
+	case LimitHeaderBytes:
+		return "header section size"
+	case LimitHeaderCount:
+		return "header count"
+	case LimitBodyLength:
+		return "body length"
+	}
+	return fmt.Sprintf("LimitKind(%d)", int(k))
+}
+
+// LimitError is returned when a response exceeds one of the Limits. It carries
+// the headers read before.
+type LimitError struct {
+	Kind    LimitKind
+	Limit   int
+	Headers []Header
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("response %s exceeds the limit of %d", e.Kind, e.Limit)
+}
+
+// ErrLineTooLong is returned by ReadLine for a line longer than allowed.
+var ErrLineTooLong = errors.New("line too long")
+
+// sliceReader reads the lines of a response head.
+type sliceReader interface {
+	ReadSlice(delim byte) ([]byte, error)
+}
+
+// ReadLine reads a line up to and including '\n', failing with ErrLineTooLong
+// once it is longer than max without buffering more.
+func ReadLine(r *bufio.Reader, max int) ([]byte, error) {
+	return readLine(r, max)
+}
+
+func readLine(r sliceReader, max int) ([]byte, error) {
+	var line []byte
+	for {
+		frag, err := r.ReadSlice('\n')
+		if len(line)+len(frag) > max {
+			return nil, ErrLineTooLong
+		}
+		line = append(line, frag...)
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+)
+
+const statusLinePrefix = "HTTP/"
+
+// maxBannerQuote is the number of banner bytes quoted in a NonHTTPError message.
+const maxBannerQuote = 32
+
+// NonHTTPError is returned when the server answers with something else than an
+// HTTP status line, e.g. an HTTP/0.9 body, an SSH or SMTP banner or a TLS alert.
+type NonHTTPError struct {
+	Banner []byte // bytes received when the response was found not to be HTTP
+}
+
+func (e *NonHTTPError) Error() string {
+	banner := e.Banner
+	if len(banner) > maxBannerQuote {
+		banner = banner[:maxBannerQuote]
+	}
+	return fmt.Sprintf("ReadStatusLine: non-HTTP response starting with %q", banner)
+}
+
+// DetectNonHTTP peeks at the start of a response in r, returning a *NonHTTPError
+// with the bytes buffered so far if it can't be an HTTP status line. Bytes are
+// peeked one at a time, so a short banner is reported as soon as it differs from
+// "HTTP/" without waiting for more. Nothing is consumed from r.
+func DetectNonHTTP(r *bufio.Reader) error {
+	for n := 1; n <= len(statusLinePrefix); n++ {
+		start, err := r.Peek(n)
+		if !bytes.HasPrefix([]byte(statusLinePrefix), start) {
+			banner, _ := r.Peek(r.Buffered())
+			return &NonHTTPError{Banner: append([]byte(nil), banner...)}
+		}
+		if err != nil {
+			// cut short, the status line parsing tells
+			return nil
+		}
+	}
+	return nil
+}
+
+// DetectHTTP09 reports whether the response in r is to be read as an HTTP/0.9
+// one, its body being the whole stream. A non-HTTP response fails with a
+// *NonHTTPError unless options.HTTP09Fallback is set.
+func DetectHTTP09(r *bufio.Reader, options ParseOptions) (bool, []Anomaly, error) {
+	a := anomalies{mode: options.Mode}
+	http09, err := a.http09(r, options.HTTP09Fallback)
+	return http09, a.list, err
+}
+
+func (a *anomalies) http09(r *bufio.Reader, fallback bool) (bool, error) {
+	err := DetectNonHTTP(r)
+	if err == nil {
+		return false, nil
+	}
+	if !fallback {
+		return false, err
+	}
+	// the first line of the banner is the one reported
+	banner := err.(*NonHTTPError).Banner
+	if i := bytes.IndexByte(banner, '\n'); i >= 0 {
+		banner = banner[:i+1]
+	}
+	if err := a.add(AnomalyHTTP09, banner); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -2,7 +2,6 @@ package client
 
 import (
 	"bufio"
-	"bytes"
 	"fmt"
 	"io"
 	"strconv"
@@ -89,39 +88,26 @@ func (r *reader) ReadStatusCode() (int, error) {
 
 // ReadStatusLine reads the status line.
 func (r *reader) ReadStatusLine() (Version, int, string, error) {
-	version, err := r.ReadVersion()
-	if err != nil {
-		return Version{}, 0, "", err
-	}
-	code, err := r.ReadStatusCode()
-	if err != nil {
-		return Version{}, 0, "", err
-	}
-	msg, _, err := r.ReadLine()
-	return version, code, string(msg), err
+	version, code, msg, _, err := ReadStatusLine(r.Reader, ParseOptions{})
+	return version, code, msg, err
 }
 
 // ReadHeader reads a http header.
 func (r *reader) ReadHeader() (string, string, bool, error) {
-	line, err := r.readLine()
+	line, err := readLine(r.Reader, DefaultMaxHeaderLineLength)
+	if err == ErrLineTooLong {
+		return "", "", false, &LimitError{Kind: LimitHeaderLineLength, Limit: DefaultMaxHeaderLineLength}
+	}
 	if err != nil {
 		return "", "", false, err
 	}
 	if line := string(line); line == "\r\n" || line == "\n" {
 		return "", "", true, nil
 	}
-	v := bytes.SplitN(line, []byte(":"), 2)
-	if len(v) != 2 {
-		return "", "", false, fmt.Errorf("invalid header line: %q", line)
-	}
-	return string(bytes.TrimSpace(v[0])), string(bytes.TrimSpace(v[1])), false, nil
+	key, value, _, err := ParseHeaderLine(line, Lenient)
+	return key, value, false, err
 }
 
 func (r *reader) ReadBody() io.Reader {
 	return r
 }
-
-// readLine returns a []byte terminated by a \r\n.
-func (r *reader) readLine() ([]byte, error) {
-	return r.ReadBytes('\n')
-}
@@ -2,6 +2,9 @@ package client
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
+	"io"
 	"strings"
 	"testing"
 
@@ -33,3 +36,171 @@ func TestStatusCode(t *testing.T) {
 		require.Equal(t, test.result, result)
 	}
 }
+
+func TestReadResponseAnomalies(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		kinds    []AnomalyKind
+	}{
+		{"valid", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n", nil},
+		{"bare lf", "HTTP/1.1 200 OK\nContent-Length: 0\r\n\r\n", []AnomalyKind{AnomalyBareLF}},
+		{"nul byte", "HTTP/1.1 200 OK\r\nX-A: a\x00b\r\nContent-Length: 0\r\n\r\n", []AnomalyKind{AnomalyNUL}},
+		{"invalid version", "HTTP/a.b 200 OK\r\nContent-Length: 0\r\n\r\n", []AnomalyKind{AnomalyInvalidVersion}},
+		{"status code length", "HTTP/1.1 2000 OK\r\nContent-Length: 0\r\n\r\n", []AnomalyKind{AnomalyStatusCodeLength}},
+		{"whitespace before colon", "HTTP/1.1 200 OK\r\nContent-Length : 0\r\n\r\n", []AnomalyKind{AnomalyWhitespaceBeforeColon}},
+		{"invalid header name", "HTTP/1.1 200 OK\r\nX(A): a\r\nContent-Length: 0\r\n\r\n", []AnomalyKind{AnomalyInvalidHeaderName}},
+		{"obs-fold", "HTTP/1.1 200 OK\r\nX-A: a\r\n b\r\nContent-Length: 0\r\n\r\n", []AnomalyKind{AnomalyObsFold}},
+		{"conflicting content-length", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nContent-Length: 1\r\n\r\n", []AnomalyKind{AnomalyConflictingContentLength}},
+		{"content-length and transfer-encoding", "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", []AnomalyKind{AnomalyContentLengthWithTransferEncoding}},
+		{"transfer-encoding case", "HTTP/1.1 200 OK\r\nTransfer-Encoding: Chunked\r\n\r\n0\r\n\r\n", []AnomalyKind{AnomalyTransferEncoding}},
+		{"transfer-encoding codings", "HTTP/1.1 200 OK\r\nTransfer-Encoding: gzip, chunked\r\n\r\n0\r\n\r\n", []AnomalyKind{AnomalyTransferEncoding}},
+		{"transfer-encoding chunked not last", "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked, gzip\r\n\r\nabc", []AnomalyKind{AnomalyTransferEncoding}},
+		{"transfer-encoding unknown", "HTTP/1.1 200 OK\r\nTransfer-Encoding: xchunked\r\n\r\nabc", []AnomalyKind{AnomalyTransferEncoding}},
+		{"transfer-encoding fields", "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", []AnomalyKind{AnomalyTransferEncoding}},
+		{"transfer-encoding case and content-length", "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nTransfer-Encoding: Chunked\r\n\r\n0\r\n\r\n", []AnomalyKind{AnomalyTransferEncoding, AnomalyContentLengthWithTransferEncoding}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := NewClient(bytes.NewBufferString(test.response)).ReadResponse(false)
+			require.Nil(t, err)
+			var kinds []AnomalyKind
+			for _, a := range resp.Anomalies {
+				kinds = append(kinds, a.Kind)
+			}
+			require.Equal(t, test.kinds, kinds)
+
+			_, err = NewClientWithMode(bytes.NewBufferString(test.response), Strict).ReadResponse(false)
+			if test.kinds == nil {
+				require.Nil(t, err)
+				return
+			}
+			var anomalyErr *AnomalyError
+			require.ErrorAs(t, err, &anomalyErr)
+			require.Equal(t, test.kinds[0], anomalyErr.Kind)
+		})
+	}
+
+	// Transfer-Encoding wins over Content-Length
+	resp, err := NewClient(bytes.NewBufferString("HTTP/1.1 200 OK\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n")).ReadResponse(false)
+	require.Nil(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+	require.Equal(t, "hello", string(body))
+
+	// codings are case insensitive and chunked frames the body when applied last
+	for _, te := range []string{"Chunked", "gzip, chunked", "gzip\r\nTransfer-Encoding: CHUNKED"} {
+		resp, err = NewClient(bytes.NewBufferString("HTTP/1.1 200 OK\r\nContent-Length: 3\r\nTransfer-Encoding: " + te + "\r\n\r\n5\r\nhello\r\n0\r\n\r\nHTTP/1.1")).ReadResponse(false)
+		require.Nil(t, err)
+		require.Equal(t, "chunked", resp.TransferEncoding())
+		body, err = io.ReadAll(resp.Body)
+		require.Nil(t, err)
+		require.Equal(t, "hello", string(body), te)
+	}
+
+	resp, err = NewClient(bytes.NewBufferString("HTTP/1.1 200 OK\r\nX-A: a\r\n\tb\r\n\r\n")).ReadResponse(true)
+	require.Nil(t, err)
+	require.Equal(t, []Header{{"X-A", "a b"}}, resp.Headers)
+}
+
+func TestChunkedReader(t *testing.T) {
+	raw := "3;a=1;b\r\nabc\r\n2\r\nde\r\n0\r\nX-Sum: 1\r\nX-Fold: a\r\n b\r\n\r\n"
+	r := bufio.NewReader(strings.NewReader(raw + "HTTP/1.1 200 OK\r\n"))
+	cr := NewChunkedReader(r, ParseOptions{})
+	body, err := io.ReadAll(cr)
+	require.Nil(t, err)
+	require.Equal(t, "abcde", string(body))
+	require.Equal(t, []Chunk{{Size: 3, Extensions: "a=1;b"}, {Size: 2}, {Size: 0}}, cr.Chunks())
+	require.Equal(t, []Header{{"X-Sum", "1"}, {"X-Fold", "a b"}}, cr.Trailers())
+	require.Len(t, cr.Anomalies(), 1)
+	require.Equal(t, raw, string(cr.Raw()))
+	require.False(t, cr.Truncated())
+
+	// the next message is left unread
+	next, err := r.ReadString('\n')
+	require.Nil(t, err)
+	require.Equal(t, "HTTP/1.1 200 OK\r\n", next)
+
+	// the chunks and raw bytes kept are bounded, not the body
+	cr = NewChunkedReader(strings.NewReader(raw), ParseOptions{Limits: Limits{MaxRawChunkedLength: 10, MaxChunkCount: 2}})
+	body, err = io.ReadAll(cr)
+	require.Nil(t, err)
+	require.Equal(t, "abcde", string(body))
+	require.Equal(t, []Chunk{{Size: 3, Extensions: "a=1;b"}, {Size: 2}}, cr.Chunks())
+	require.Equal(t, raw[:10], string(cr.Raw()))
+	require.True(t, cr.Truncated())
+
+	for _, invalid := range []string{"x\r\n", "3\r\nabcd\r\n", "0x3\r\nabc\r\n0\r\n\r\n", "3\r\nab"} {
+		_, err := io.ReadAll(NewChunkedReader(strings.NewReader(invalid), ParseOptions{}))
+		require.NotNil(t, err, invalid)
+	}
+}
+
+func TestNonHTTPResponse(t *testing.T) {
+	banner := "SSH-2.0-OpenSSH_9.6\r\n"
+	_, err := NewClient(bytes.NewBufferString(banner)).ReadResponse(false)
+	var nonHTTP *NonHTTPError
+	require.ErrorAs(t, err, &nonHTTP)
+	require.Equal(t, banner, string(nonHTTP.Banner))
+
+	// a TLS alert closing the connection
+	alert := "\x15\x03\x01\x00\x02\x02\x46"
+	_, err = NewClient(bytes.NewBufferString(alert)).ReadResponse(false)
+	require.ErrorAs(t, err, &nonHTTP)
+	require.Equal(t, alert, string(nonHTTP.Banner))
+
+	// a banner shorter than the status line prefix, the server waiting
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go func() {
+		_, _ = pw.Write([]byte("220"))
+	}()
+	err = DetectNonHTTP(bufio.NewReader(pr))
+	require.ErrorAs(t, err, &nonHTTP)
+	require.Equal(t, "220", string(nonHTTP.Banner))
+
+	// cut short responses are not banners
+	_, err = NewClient(bytes.NewBufferString("HTT")).ReadResponse(false)
+	require.NotNil(t, err)
+	require.False(t, errors.As(err, &nonHTTP))
+
+	resp, err := NewClientWithOptions(bytes.NewBufferString(banner), ParseOptions{HTTP09Fallback: true}).ReadResponse(false)
+	require.Nil(t, err)
+	require.Equal(t, HTTP_0_9, resp.Version)
+	require.Equal(t, []Anomaly{{AnomalyHTTP09, banner}}, resp.Anomalies)
+	body, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+	require.Equal(t, banner, string(body))
+
+	_, err = NewClientWithOptions(bytes.NewBufferString(banner), ParseOptions{Mode: Strict, HTTP09Fallback: true}).ReadResponse(false)
+	var anomalyErr *AnomalyError
+	require.ErrorAs(t, err, &anomalyErr)
+}
+
+func TestReadResponseLimits(t *testing.T) {
+	limits := Limits{MaxStatusLineLength: 32, MaxHeaderLineLength: 16, MaxHeaderBytes: 30, MaxHeaderCount: 3}
+	tests := []struct {
+		name     string
+		response string
+		kind     LimitKind
+		headers  int
+	}{
+		{"status line", "HTTP/1.1 200 " + strings.Repeat("K", 32) + "\r\n\r\n", LimitStatusLineLength, 0},
+		{"header line", "HTTP/1.1 200 OK\r\nA: 1\r\nB: " + strings.Repeat("b", 16) + "\r\n\r\n", LimitHeaderLineLength, 1},
+		{"header bytes", "HTTP/1.1 200 OK\r\nAa: 111111\r\nBb: 222222\r\nCc: 333333\r\n\r\n", LimitHeaderBytes, 2},
+		{"header count", "HTTP/1.1 200 OK\r\nA: 1\r\nB: 2\r\nC: 3\r\nD: 4\r\n\r\n", LimitHeaderCount, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewClientWithOptions(bytes.NewBufferString(test.response), ParseOptions{Limits: limits}).ReadResponse(false)
+			var limitErr *LimitError
+			require.ErrorAs(t, err, &limitErr)
+			require.Equal(t, test.kind, limitErr.Kind)
+			require.Len(t, limitErr.Headers, test.headers)
+
+			// within the default limits
+			_, err = NewClient(bytes.NewBufferString(test.response)).ReadResponse(false)
+			require.Nil(t, err)
+		})
+	}
+}
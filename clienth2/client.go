@@ -0,0 +1,228 @@
+package clienth2
+
+import (
+	"strconv"
+
+	"github.com/secoba/rawhttp/client"
+	"golang.org/x/net/http2"
+)
+
+// Request is an HTTP/2 request whose header fields are sent as is.
+type Request struct {
+	Headers  []client.Header // header fields in order, pseudo-headers included
+	Body     []byte
+	Trailers []client.Header
+	// MaxFrameSize splits the header blocks and the body in frames of at most
+	// this size, the server's maximum if not set
+	MaxFrameSize int
+}
+
+// NewRequest returns a request with the pseudo-headers of method, scheme,
+// authority and path followed by headers.
+func NewRequest(method, scheme, authority, path string, headers []client.Header, body []byte) *Request {
+	fields := []client.Header{
+		{Key: ":method", Value: method},
+		{Key: ":scheme", Value: scheme},
+		{Key: ":authority", Value: authority},
+		{Key: ":path", Value: path},
+	}
+	return &Request{Headers: append(fields, headers...), Body: body}
+}
+
+// Response is an HTTP/2 response read from a stream.
+type Response struct {
+	StreamID uint32
+	Status   int               // value of :status, 0 if missing or invalid
+	Headers  []client.Header   // header fields as received, pseudo-headers included
+	Interim  [][]client.Header // header fields of the 1xx responses
+	Body     []byte
+	Trailers []client.Header
+	Frames   []http2.FrameHeader // every frame read while waiting for the response
+}
+
+// Do sends req on a new stream and reads its response, acknowledging SETTINGS
+// and PING frames and granting flow control credit on the way. The body is sent
+// within the flow control windows of the server, waiting for WINDOW_UPDATE
+// frames as needed, the Framer being left for deliberate overruns. Streams are
+// handled one at a time, frames of other streams are dropped. On error the
+// response read so far is returned along with it, a RST_STREAM from the server
+// being reported as an http2.StreamError.
+func (c *Conn) Do(req *Request) (*Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.newStream(c.reserveStreamID())
+	if done, err := c.writeRequest(s, req); done || err != nil {
+		return s.resp, err
+	}
+	return s.resp, c.readResponse(s)
+}
+
+// stream is the state of the stream being handled
+type stream struct {
+	resp        *Response
+	sendWindow  int64  // flow control window of the stream on the server side
+	block       []byte // header block being read
+	blockStream uint32 // stream of the header block being read, 0 if not resp's
+	blockEnds   bool   // whether the header block ends its stream
+}
+
+func (c *Conn) newStream(streamID uint32) *stream {
+	return &stream{resp: &Response{StreamID: streamID}, sendWindow: int64(c.initialWindowSize)}
+}
+
+// writeRequest writes req on the stream of s, returning whether its response
+// was read in full while waiting for flow control credit.
+func (c *Conn) writeRequest(s *stream, req *Request) (bool, error) {
+	streamID := s.resp.StreamID
+	hasTrailers := len(req.Trailers) > 0
+	endStream := len(req.Body) == 0 && !hasTrailers
+	if err := c.WriteHeaderBlock(streamID, c.encodeHeaders(req.Headers), endStream, req.MaxFrameSize); err != nil {
+		return false, err
+	}
+	maxFrameSize := req.MaxFrameSize
+	if maxFrameSize <= 0 {
+		maxFrameSize = int(c.maxFrameSize)
+	}
+	for rest := req.Body; len(rest) > 0; {
+		window := min(c.sendWindow, s.sendWindow)
+		if window <= 0 {
+			if done, err := c.readFrame(s); done || err != nil {
+				return done, err
+			}
+			continue
+		}
+		var data []byte
+		data, rest = splitFrame(rest, int(min(int64(maxFrameSize), window)))
+		if err := c.Framer.WriteData(streamID, len(rest) == 0 && !hasTrailers, data); err != nil {
+			return false, err
+		}
+		c.sendWindow -= int64(len(data))
+		s.sendWindow -= int64(len(data))
+	}
+	if hasTrailers {
+		return false, c.WriteHeaderBlock(streamID, c.encodeHeaders(req.Trailers), true, req.MaxFrameSize)
+	}
+	return false, nil
+}
+
+// readResponse reads frames until the stream of s ends.
+func (c *Conn) readResponse(s *stream) error {
+	for {
+		if done, err := c.readFrame(s); done || err != nil {
+			return err
+		}
+	}
+}
+
+// readFrame reads and handles a frame, returning whether it ends the stream of s.
+func (c *Conn) readFrame(s *stream) (bool, error) {
+	resp := s.resp
+	f, err := c.Framer.ReadFrame()
+	if err != nil {
+		return false, err
+	}
+	resp.Frames = append(resp.Frames, f.Header())
+
+	switch f := f.(type) {
+	case *http2.SettingsFrame:
+		if f.IsAck() {
+			return false, nil
+		}
+		if v, ok := f.Value(http2.SettingMaxFrameSize); ok {
+			c.maxFrameSize = v
+		}
+		if v, ok := f.Value(http2.SettingHeaderTableSize); ok {
+			c.enc.SetMaxDynamicTableSizeLimit(v)
+		}
+		if v, ok := f.Value(http2.SettingInitialWindowSize); ok {
+			// applies to the streams already open, rfc 7540 s6.9.2
+			s.sendWindow += int64(v) - int64(c.initialWindowSize)
+			c.initialWindowSize = v
+		}
+		return false, c.Framer.WriteSettingsAck()
+	case *http2.WindowUpdateFrame:
+		switch f.StreamID {
+		case 0:
+			c.sendWindow += int64(f.Increment)
+		case resp.StreamID:
+			s.sendWindow += int64(f.Increment)
+		}
+	case *http2.PingFrame:
+		if !f.IsAck() {
+			return false, c.Framer.WritePing(true, f.Data)
+		}
+	case *http2.GoAwayFrame:
+		if f.LastStreamID < resp.StreamID {
+			return false, http2.GoAwayError{LastStreamID: f.LastStreamID, ErrCode: f.ErrCode, DebugData: string(f.DebugData())}
+		}
+	case *http2.RSTStreamFrame:
+		if f.StreamID == resp.StreamID {
+			return false, http2.StreamError{StreamID: f.StreamID, Code: f.ErrCode}
+		}
+	case *http2.PushPromiseFrame:
+		// decoded to keep the HPACK state, then dropped
+		s.block, s.blockStream, s.blockEnds = append([]byte(nil), f.HeaderBlockFragment()...), 0, false
+		if f.HeadersEnded() {
+			_, err = c.decodeHeaders(s.block)
+			return false, err
+		}
+	case *http2.HeadersFrame:
+		s.block, s.blockStream, s.blockEnds = append([]byte(nil), f.HeaderBlockFragment()...), f.StreamID, f.StreamEnded()
+		if f.HeadersEnded() {
+			return c.headerBlock(resp, s.block, s.blockStream, s.blockEnds)
+		}
+	case *http2.ContinuationFrame:
+		s.block = append(s.block, f.HeaderBlockFragment()...)
+		if f.HeadersEnded() {
+			return c.headerBlock(resp, s.block, s.blockStream, s.blockEnds)
+		}
+	case *http2.DataFrame:
+		if f.Length > 0 {
+			// give the credit back right away
+			if err = c.Framer.WriteWindowUpdate(0, f.Length); err == nil && !f.StreamEnded() {
+				err = c.Framer.WriteWindowUpdate(f.StreamID, f.Length)
+			}
+		}
+		if f.StreamID == resp.StreamID {
+			resp.Body = append(resp.Body, f.Data()...)
+			return f.StreamEnded(), err
+		}
+		return false, err
+	}
+	return false, nil
+}
+
+// headerBlock decodes a complete header block received on streamID, returning
+// whether it ends the stream of resp.
+func (c *Conn) headerBlock(resp *Response, block []byte, streamID uint32, endStream bool) (bool, error) {
+	headers, err := c.decodeHeaders(block)
+	if err != nil || streamID != resp.StreamID {
+		return false, err
+	}
+	switch {
+	case resp.Headers != nil:
+		resp.Trailers = headers
+	case isInterim(headers) && !endStream:
+		resp.Interim = append(resp.Interim, headers)
+	default:
+		resp.Headers = headers
+		resp.Status = status(headers)
+	}
+	return endStream, nil
+}
+
+func status(headers []client.Header) int {
+	for _, h := range headers {
+		if h.Key == ":status" {
+			code, _ := strconv.Atoi(h.Value)
+			return code
+		}
+	}
+	return 0
+}
+
+func isInterim(headers []client.Header) bool {
+	code := status(headers)
+	return code >= 100 && code < 200
+}
@@ -0,0 +1,215 @@
+package clienth2
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/secoba/rawhttp/client"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/net/http2/hpack"
+)
+
+// echoHandler answers with the method, path, X-Test header and body of the
+// request, 100KB of data for /big, and an X-Trailer trailer
+func echoHandler(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	w.Header().Set("Trailer", "X-Trailer")
+	w.Header().Set("X-Echo", r.Header.Get("X-Test"))
+	if r.URL.Path == "/big" {
+		_, _ = w.Write(bytes.Repeat([]byte("x"), 100<<10))
+	} else {
+		_, _ = io.WriteString(w, r.Method+" "+r.URL.Path+" "+string(body))
+	}
+	w.Header().Set("X-Trailer", "done")
+}
+
+func dial(t *testing.T, addr string, options Options) *Conn {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := Dial(ctx, addr, options)
+	require.Nil(t, err)
+	t.Cleanup(func() { c.Close() })
+	require.Nil(t, c.SetDeadline(time.Now().Add(5*time.Second)))
+	return c
+}
+
+func TestConnDo(t *testing.T) {
+	h2cServer := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(echoHandler), &http2.Server{}))
+	defer h2cServer.Close()
+	tlsServer := httptest.NewUnstartedServer(http.HandlerFunc(echoHandler))
+	require.Nil(t, http2.ConfigureServer(tlsServer.Config, &http2.Server{}))
+	tlsServer.TLS = tlsServer.Config.TLSConfig
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+
+	servers := map[string]Options{
+		h2cServer.Listener.Addr().String(): {},
+		tlsServer.Listener.Addr().String(): {TLSConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+	for addr, options := range servers {
+		c := dial(t, addr, options)
+
+		req := NewRequest("POST", "https", addr, "/echo", []client.Header{{Key: "x-test", Value: "a"}}, []byte("body"))
+		resp, err := c.Do(req)
+		require.Nil(t, err)
+		require.Equal(t, uint32(1), resp.StreamID)
+		require.Equal(t, 200, resp.Status)
+		require.Equal(t, "POST /echo body", string(resp.Body))
+		require.Contains(t, resp.Headers, client.Header{Key: "x-echo", Value: "a"})
+		require.Equal(t, []client.Header{{Key: "x-trailer", Value: "done"}}, resp.Trailers)
+
+		// a header block in many CONTINUATION frames and a body larger than
+		// the flow control window
+		req = NewRequest("GET", "https", addr, "/big", []client.Header{{Key: "x-test", Value: strings.Repeat("b", 100)}}, nil)
+		req.MaxFrameSize = 16
+		resp, err = c.Do(req)
+		require.Nil(t, err)
+		require.Equal(t, uint32(3), resp.StreamID)
+		require.Len(t, resp.Body, 100<<10)
+		require.Contains(t, resp.Headers, client.Header{Key: "x-echo", Value: strings.Repeat("b", 100)})
+
+		// invalid header names reset the stream
+		resp, err = c.Do(NewRequest("GET", "https", addr, "/", []client.Header{{Key: "x:bad\r\n", Value: "a"}}, nil))
+		var streamErr http2.StreamError
+		require.True(t, errors.As(err, &streamErr), err)
+		require.Equal(t, resp.StreamID, streamErr.StreamID)
+		require.Equal(t, http2.ErrCodeProtocol, streamErr.Code)
+
+		// the connection is still usable
+		resp, err = c.Do(NewRequest("GET", "https", addr, "/after", nil, nil))
+		require.Nil(t, err)
+		require.Equal(t, "GET /after ", string(resp.Body))
+	}
+}
+
+// frameServer accepts one HTTP/2 connection and sends the header fields of
+// the first request it receives, as decoded, answering it with a 204.
+func frameServer(t *testing.T) (string, chan []hpack.HeaderField) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { l.Close() })
+	received := make(chan []hpack.HeaderField, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		preface := make([]byte, len(http2.ClientPreface))
+		if _, err := io.ReadFull(c, preface); err != nil {
+			return
+		}
+		fr := http2.NewFramer(c, c)
+		fr.AllowIllegalReads = true
+		_ = fr.WriteSettings()
+		var block []byte
+		for {
+			f, err := fr.ReadFrame()
+			if err != nil {
+				return
+			}
+			switch f := f.(type) {
+			case *http2.HeadersFrame:
+				block = append(block, f.HeaderBlockFragment()...)
+				if !f.HeadersEnded() {
+					continue
+				}
+			case *http2.ContinuationFrame:
+				block = append(block, f.HeaderBlockFragment()...)
+				if !f.HeadersEnded() {
+					continue
+				}
+			default:
+				continue
+			}
+			fields, _ := hpack.NewDecoder(4096, nil).DecodeFull(block)
+			received <- fields
+			var buf bytes.Buffer
+			_ = hpack.NewEncoder(&buf).WriteField(hpack.HeaderField{Name: ":status", Value: "204"})
+			_ = fr.WriteHeaders(http2.HeadersFrameParam{StreamID: f.Header().StreamID, BlockFragment: buf.Bytes(), EndStream: true, EndHeaders: true})
+			return
+		}
+	}()
+	return l.Addr().String(), received
+}
+
+func TestConnRawHeaders(t *testing.T) {
+	addr, received := frameServer(t)
+	c := dial(t, addr, Options{})
+
+	// pseudo-headers out of order and twice, names with case and colons
+	headers := []client.Header{
+		{Key: ":path", Value: "/a b"},
+		{Key: "X-Case", Value: "1"},
+		{Key: ":method", Value: "GET"},
+		{Key: ":method", Value: "POST"},
+		{Key: "a:b", Value: "c\r\nd"},
+	}
+	resp, err := c.Do(&Request{Headers: headers, MaxFrameSize: 4})
+	require.Nil(t, err)
+	require.Equal(t, 204, resp.Status)
+
+	fields := <-received
+	require.Len(t, fields, len(headers))
+	for i, h := range headers {
+		require.Equal(t, h.Key, fields[i].Name)
+		require.Equal(t, h.Value, fields[i].Value)
+	}
+}
+
+func TestConnFrames(t *testing.T) {
+	server := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(echoHandler), &http2.Server{}))
+	defer server.Close()
+	c := dial(t, server.Listener.Addr().String(), Options{SkipPreface: true})
+
+	// a hand-built preface, then a stream cancelled by the client
+	_, err := c.Write([]byte(http2.ClientPreface))
+	require.Nil(t, err)
+	require.Nil(t, c.Framer.WriteSettings(http2.Setting{ID: http2.SettingInitialWindowSize, Val: 1 << 20}))
+	streamID := c.NextStreamID()
+	block := c.EncodeHeaders(NewRequest("POST", "http", "h2c", "/", nil, nil).Headers)
+	require.Nil(t, c.WriteHeaderBlock(streamID, block, false, 0))
+	require.Nil(t, c.Framer.WriteData(streamID, false, []byte("partial")))
+	require.Nil(t, c.Framer.WriteRSTStream(streamID, http2.ErrCodeCancel))
+
+	resp, err := c.Do(NewRequest("GET", "http", "h2c", "/next", nil, nil))
+	require.Nil(t, err)
+	require.Equal(t, uint32(3), resp.StreamID)
+	require.Equal(t, "GET /next ", string(resp.Body))
+	require.Equal(t, http2.FrameSettings, resp.Frames[0].Type)
+}
+
+func TestConnFlowControl(t *testing.T) {
+	// the server grants the minimal windows, the body needs several updates
+	lengths := make(chan int, 1)
+	server := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		lengths <- len(body)
+	}), &http2.Server{MaxUploadBufferPerConnection: 1 << 16, MaxUploadBufferPerStream: 1 << 16}))
+	defer server.Close()
+	c := dial(t, server.Listener.Addr().String(), Options{})
+
+	body := bytes.Repeat([]byte("x"), 300<<10)
+	resp, err := c.Do(NewRequest("POST", "http", "h2c", "/", nil, body))
+	require.Nil(t, err)
+	require.Equal(t, 200, resp.Status)
+	require.Equal(t, len(body), <-lengths)
+	var updates int
+	for _, f := range resp.Frames {
+		if f.Type == http2.FrameWindowUpdate {
+			updates++
+		}
+	}
+	require.Greater(t, updates, 0)
+}
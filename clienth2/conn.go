@@ -0,0 +1,198 @@
+package clienth2
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/secoba/rawhttp/client"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// NextProto is the ALPN protocol negotiated for HTTP/2 over TLS.
+const NextProto = "h2"
+
+const (
+	defaultMaxFrameSize    = 16384
+	defaultHeaderTableSize = 4096
+	defaultWindowSize      = 65535
+)
+
+// ErrNoH2 is returned when the server did not negotiate h2 with ALPN.
+var ErrNoH2 = errors.New("server did not negotiate h2")
+
+// DialFunc opens the connection HTTP/2 is spoken on.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Options configures an HTTP/2 connection.
+type Options struct {
+	TLSConfig *tls.Config // speaks h2 negotiated with ALPN, prior-knowledge h2c if nil
+	Dial      DialFunc    // net.Dialer if not set
+	// Settings are sent in the SETTINGS frame following the client preface
+	Settings []http2.Setting
+	// SkipPreface leaves the client preface and SETTINGS frame to the caller,
+	// for hand-built ones
+	SkipPreface bool
+}
+
+// Conn is an HTTP/2 client connection. Requests are written with Do, or frame
+// by frame with the Framer, HEADERS blocks being built with EncodeHeaders and
+// read with DecodeHeaders to keep the HPACK state of the connection in sync.
+// The Framer ignores flow control and must not be used while Do is in progress.
+type Conn struct {
+	net.Conn
+	Framer *http2.Framer
+
+	mu           sync.Mutex // serializes the streams of Do
+	encBuf       bytes.Buffer
+	enc          *hpack.Encoder
+	dec          *hpack.Decoder
+	nextStreamID uint32
+	maxFrameSize uint32 // SETTINGS_MAX_FRAME_SIZE of the server
+
+	initialWindowSize uint32 // SETTINGS_INITIAL_WINDOW_SIZE of the server
+	sendWindow        int64  // flow control window of the connection on the server side
+}
+
+// Dial connects to addr and starts HTTP/2 on the connection.
+func Dial(ctx context.Context, addr string, options Options) (*Conn, error) {
+	dial := options.Dial
+	if dial == nil {
+		var d net.Dialer
+		dial = d.DialContext
+	}
+	c, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if options.TLSConfig != nil {
+		config := options.TLSConfig.Clone()
+		config.NextProtos = []string{NextProto}
+		tlsConn := tls.Client(c, config)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("tls handshake error: %w", err)
+		}
+		if proto := tlsConn.ConnectionState().NegotiatedProtocol; proto != NextProto {
+			c.Close()
+			return nil, fmt.Errorf("%w, got %q", ErrNoH2, proto)
+		}
+		c = tlsConn
+	}
+	conn, err := NewConn(c, options)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// NewConn starts HTTP/2 on c, writing the client preface and SETTINGS frame
+// unless options.SkipPreface is set. The Framer allows illegal frames to be
+// written and read.
+func NewConn(c net.Conn, options Options) (*Conn, error) {
+	conn := &Conn{
+		Conn:         c,
+		Framer:       http2.NewFramer(c, c),
+		nextStreamID: 1,
+		maxFrameSize: defaultMaxFrameSize,
+
+		initialWindowSize: defaultWindowSize,
+		sendWindow:        defaultWindowSize,
+	}
+	conn.Framer.AllowIllegalWrites = true
+	conn.Framer.AllowIllegalReads = true
+	conn.enc = hpack.NewEncoder(&conn.encBuf)
+	conn.dec = hpack.NewDecoder(defaultHeaderTableSize, nil)
+	if options.SkipPreface {
+		return conn, nil
+	}
+	if _, err := c.Write([]byte(http2.ClientPreface)); err != nil {
+		return nil, err
+	}
+	if err := conn.Framer.WriteSettings(options.Settings...); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// NextStreamID reserves the identifier of a new client stream.
+func (c *Conn) NextStreamID() uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reserveStreamID()
+}
+
+func (c *Conn) reserveStreamID() uint32 {
+	id := c.nextStreamID
+	c.nextStreamID += 2
+	return id
+}
+
+// EncodeHeaders returns the HPACK block of headers, names and values encoded
+// as is: pseudo-headers, order, case, CR, LF and colons are kept.
+func (c *Conn) EncodeHeaders(headers []client.Header) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.encodeHeaders(headers)
+}
+
+func (c *Conn) encodeHeaders(headers []client.Header) []byte {
+	c.encBuf.Reset()
+	for _, h := range headers {
+		_ = c.enc.WriteField(hpack.HeaderField{Name: h.Key, Value: h.Value})
+	}
+	return append([]byte(nil), c.encBuf.Bytes()...)
+}
+
+// DecodeHeaders decodes a complete HPACK block received on the connection.
+func (c *Conn) DecodeHeaders(block []byte) ([]client.Header, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.decodeHeaders(block)
+}
+
+func (c *Conn) decodeHeaders(block []byte) ([]client.Header, error) {
+	fields, err := c.dec.DecodeFull(block)
+	if err != nil {
+		return nil, err
+	}
+	headers := make([]client.Header, 0, len(fields))
+	for _, f := range fields {
+		headers = append(headers, client.Header{Key: f.Name, Value: f.Value})
+	}
+	return headers, nil
+}
+
+// WriteHeaderBlock writes block on streamID as a HEADERS frame followed by
+// CONTINUATION frames of at most maxFrameSize bytes, the server's if not set.
+func (c *Conn) WriteHeaderBlock(streamID uint32, block []byte, endStream bool, maxFrameSize int) error {
+	if maxFrameSize <= 0 {
+		maxFrameSize = int(c.maxFrameSize)
+	}
+	first, rest := splitFrame(block, maxFrameSize)
+	err := c.Framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      streamID,
+		BlockFragment: first,
+		EndStream:     endStream,
+		EndHeaders:    len(rest) == 0,
+	})
+	for err == nil && len(rest) > 0 {
+		var fragment []byte
+		fragment, rest = splitFrame(rest, maxFrameSize)
+		err = c.Framer.WriteContinuation(streamID, len(rest) == 0, fragment)
+	}
+	return err
+}
+
+func splitFrame(b []byte, size int) ([]byte, []byte) {
+	if len(b) <= size {
+		return b, nil
+	}
+	return b[:size], b[size:]
+}
@@ -0,0 +1,46 @@
+package clienth2
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"net"
+
+	"golang.org/x/net/http2"
+)
+
+// UpgradeProto is the token of the Upgrade header asking for h2c.
+const UpgradeProto = "h2c"
+
+// UpgradeSettings returns the HTTP2-Settings header value carrying settings,
+// rfc 7540 s3.2.1.
+func UpgradeSettings(settings []http2.Setting) string {
+	payload := make([]byte, 0, 6*len(settings))
+	for _, s := range settings {
+		payload = binary.BigEndian.AppendUint16(payload, uint16(s.ID))
+		payload = binary.BigEndian.AppendUint32(payload, s.Val)
+	}
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+// NewUpgradedConn carries on c as HTTP/2 after the server accepted an h2c
+// upgrade with a 101, c starting right after the 101 response. The response to
+// the upgrade request is read with ReadResponse(1), new streams start at 3.
+func NewUpgradedConn(c net.Conn, options Options) (*Conn, error) {
+	conn, err := NewConn(c, options)
+	if err != nil {
+		return nil, err
+	}
+	// stream 1 is the upgrade request, half closed on the client side
+	conn.nextStreamID = 3
+	return conn, nil
+}
+
+// ReadResponse reads the response of streamID, for requests written frame by
+// frame or the upgrade request of an upgraded connection.
+func (c *Conn) ReadResponse(streamID uint32) (*Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.newStream(streamID)
+	return s.resp, c.readResponse(s)
+}
@@ -0,0 +1,117 @@
+package clientpipeline
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/secoba/rawhttp/client"
+)
+
+// DoBatch sends reqs pipelined on a dedicated connection, all of them serialized
+// in a single Write, and reads their responses in order. The responses read
+// before an error are returned along with it: the error belongs to the request
+// following the last response, the requests after it are left unanswered.
+func (c *PipelineClient) DoBatch(reqs []*Request) ([]*Response, error) {
+	c.connClientsLock.Lock()
+	if c.closed {
+		c.connClientsLock.Unlock()
+		return nil, ErrPipelineClientClosed
+	}
+	c.calls.Add(1)
+	c.connClientsLock.Unlock()
+	defer c.calls.Done()
+
+	ctx := c.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	tlsConfig := c.TLSConfig
+	if c.IsTLS {
+		tlsConfig = newClientTLSConfig(c.TLSConfig, c.Addr)
+	}
+	conn, err := dialAddr(ctx, c.Addr, c.Dial, c.DialDualStack, c.IsTLS, tlsConfig, c.WriteTimeout)
+	if err != nil {
+		return nil, err
+	}
+	d := newConnDesync(conn)
+	c.connClientsLock.Lock()
+	if c.closed {
+		c.connClientsLock.Unlock()
+		conn.Close()
+		return nil, ErrPipelineClientClosed
+	}
+	c.desyncs.add(d, c.MaxDesyncReports)
+	if c.batchConns == nil {
+		c.batchConns = make(map[net.Conn]struct{})
+	}
+	c.batchConns[conn] = struct{}{}
+	c.connClientsLock.Unlock()
+	defer func() {
+		c.connClientsLock.Lock()
+		delete(c.batchConns, conn)
+		c.connClientsLock.Unlock()
+		conn.Close()
+		d.close()
+	}()
+
+	// serialize every request first so they leave in one write
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	for i, req := range reqs {
+		if err := req.Write(bw); err != nil {
+			return nil, fmt.Errorf("request %d: %w", i, err)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	if c.WriteTimeout > 0 {
+		if err := conn.SetWriteDeadline(time.Now().Add(c.WriteTimeout)); err != nil {
+			return nil, err
+		}
+	}
+	n, err := conn.Write(buf.Bytes())
+	d.flushed.Add(int64(n))
+	if err != nil {
+		return nil, err
+	}
+	d.written.Store(uint64(len(reqs)))
+
+	readBufferSize := c.ReadBufferSize
+	if readBufferSize <= 0 {
+		readBufferSize = defaultReadBufferSize
+	}
+	br := bufio.NewReaderSize(conn, readBufferSize)
+	resps := make([]*Response, 0, len(reqs))
+	for i, req := range reqs {
+		if c.ReadTimeout > 0 {
+			if err := conn.SetReadDeadline(time.Now().Add(c.ReadTimeout)); err != nil {
+				return resps, err
+			}
+		}
+		seq := uint64(i + 1)
+		resp := &Response{Proxy: connProxy(conn), ConnID: d.id, Seq: seq}
+		if err := resp.read(br, req.method(), client.ParseOptions{Mode: c.ParseMode, HTTP09Fallback: c.HTTP09Fallback, Limits: c.Limits}); err != nil {
+			d.readerStopped(err)
+			return resps, err
+		}
+		d.answered(seq)
+		resps = append(resps, resp)
+		if resp.closeDelimited {
+			if seq < uint64(len(reqs)) {
+				d.readerStopped(nil)
+				return resps, ErrConnectionClosed
+			}
+			break
+		}
+	}
+	if n := br.Buffered(); n > 0 {
+		extra, _ := br.Peek(n)
+		d.anomaly(DesyncExtraBytes, uint64(len(reqs)), append([]byte(nil), extra...), fmt.Sprintf("%d bytes after the response", n))
+	}
+	return resps, nil
+}
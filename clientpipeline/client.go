@@ -7,11 +7,15 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
+	"io"
 	"net"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/secoba/rawhttp/client"
 )
 
 const DefaultMaxConnsPerHost = 512
@@ -123,8 +127,8 @@ func dialAddr(ctx context.Context, addr string, dial DialFunc, dialDualStack, is
 		} else {
 			dial = Dial
 		}
-		addr = addMissingPort(addr, isTLS)
 	}
+	addr = addMissingPort(addr, isTLS)
 	conn, err := dial(ctx, addr)
 	if err != nil {
 		return nil, err
@@ -169,9 +173,37 @@ type PipelineClient struct {
 	WriteBufferSize     int
 	ReadTimeout         time.Duration
 
+	// ParseMode selects whether malformed responses are accepted, Lenient by
+	// default, or rejected. Anomalies are reported in Response.Anomalies
+	ParseMode client.ParseMode
+	// HTTP09Fallback reads a non-HTTP response as an HTTP/0.9 one whose body
+	// lasts until the connection closes, instead of failing with a
+	// *client.NonHTTPError carrying the received bytes
+	HTTP09Fallback bool
+	// Limits bound the size of response heads and bodies, exceeding one fails the request
+	// with a *client.LimitError
+	Limits client.Limits
+
+	// MaxIdemponentCallAttempts is the number of attempts for requests failed
+	// by a connection error, DefaultMaxIdemponentCallAttempts if not set.
+	// Requests written after the server asked to close the connection are not
+	// counted, being sent again on a new connection whatever their method.
+	MaxIdemponentCallAttempts int
+	// RetryIf decides whether a non idempotent request failed by a connection
+	// error is retried, idempotent requests always are
+	RetryIf RetryIfFunc
+
+	// MaxDesyncReports is the number of connections whose desync reports are
+	// kept, the oldest ones being dropped, DefaultMaxDesyncReports if not set
+	MaxDesyncReports int
+
 	WriteTimeout    time.Duration
+	desyncs         desyncRing
 	connClients     []*pipelineConnClient
+	batchConns      map[net.Conn]struct{}
 	connClientsLock sync.Mutex
+	closed          bool
+	calls           sync.WaitGroup // calls in progress, waited for by Shutdown
 }
 
 type pipelineConnClient struct {
@@ -187,38 +219,104 @@ type pipelineConnClient struct {
 	WriteBufferSize     int
 	ReadTimeout         time.Duration
 	WriteTimeout        time.Duration
+	ParseMode           client.ParseMode
+	HTTP09Fallback      bool
+	Limits              client.Limits
+
+	MaxIdemponentCallAttempts int
+	RetryIf                   RetryIfFunc
 
 	workPool sync.Pool
 
-	chLock sync.Mutex
-	chW    chan *pipelineWork
-	chR    chan *pipelineWork
+	chLock  sync.Mutex
+	chW     chan *pipelineWork
+	chR     chan *pipelineWork
+	ctx     context.Context
+	stop    context.CancelFunc
+	done    <-chan struct{} // closed once the client is stopped
+	running bool            // whether run is serving the queue
+	wg      sync.WaitGroup
 
 	tlsConfigLock sync.Mutex
 	tlsConfig     *tls.Config
+
+	desyncs          *desyncRing
+	maxDesyncReports int
 }
 
 type pipelineWork struct {
+	reqCopy  Request
+	respCopy Response
 	req      *Request
 	resp     *Response
 	t        *time.Timer
 	deadline time.Time
+	ctx      context.Context
+	attempts int    // times the request was written
+	seq      uint64 // sequence number of the request on its connection
+	end      int64  // connection offset right after the request
 	err      error
 	done     chan struct{}
 }
 
+// expiredErr returns the error for work whose deadline passed or whose context
+// got canceled, nil if the work is still wanted
+func (w *pipelineWork) expiredErr() error {
+	if !w.deadline.IsZero() && time.Since(w.deadline) >= 0 {
+		return ErrTimeout
+	}
+	if w.ctx != nil {
+		return w.ctx.Err()
+	}
+	return nil
+}
+
 func (c *PipelineClient) Do(req *Request, resp *Response) error {
-	return c.getConnClient().Do(c.Ctx, req, resp)
+	cc, err := c.acquireConnClient()
+	if err != nil {
+		return err
+	}
+	defer c.calls.Done()
+	return cc.Do(c.Ctx, req, resp)
+}
+
+// DoTimeout performs the request and waits at most timeout for the response.
+// ErrTimeout is returned if the response isn't received in time.
+func (c *PipelineClient) DoTimeout(req *Request, resp *Response, timeout time.Duration) error {
+	return c.DoDeadline(req, resp, time.Now().Add(timeout))
+}
+
+// DoDeadline performs the request and waits for the response until deadline.
+// ErrTimeout is returned if the response isn't received in time.
+func (c *PipelineClient) DoDeadline(req *Request, resp *Response, deadline time.Time) error {
+	cc, err := c.acquireConnClient()
+	if err != nil {
+		return err
+	}
+	defer c.calls.Done()
+	return cc.DoDeadline(c.Ctx, req, resp, deadline)
+}
+
+// DoContext performs the request and waits for the response until ctx is done,
+// in which case the context error is returned.
+func (c *PipelineClient) DoContext(ctx context.Context, req *Request, resp *Response) error {
+	cc, err := c.acquireConnClient()
+	if err != nil {
+		return err
+	}
+	defer c.calls.Done()
+	return cc.DoContext(c.Ctx, ctx, req, resp)
 }
 
 func (c *pipelineConnClient) Do(ctx context.Context, req *Request, resp *Response) error {
 	c.init(ctx)
 
 	w := acquirePipelineWork(&c.workPool, 0)
-	w.req = req
-	if resp != nil {
-		w.resp = resp
-	}
+	// Make a copy of the request, the worker may still write it once Do
+	// returned on close
+	w.reqCopy = *req
+	w.req = &w.reqCopy
+	w.resp = &w.respCopy
 
 	// Put the request to outgoing queue
 	select {
@@ -238,9 +336,17 @@ func (c *pipelineConnClient) Do(ctx context.Context, req *Request, resp *Respons
 			return ErrPipelineOverflow
 		}
 	}
+	c.start()
 
 	// Wait for the response
-	<-w.done
+	select {
+	case <-w.done:
+	case <-c.done:
+		return ErrPipelineClientClosed
+	}
+	if resp != nil {
+		*resp = w.respCopy
+	}
 	err := w.err
 
 	releasePipelineWork(&c.workPool, w)
@@ -248,11 +354,136 @@ func (c *pipelineConnClient) Do(ctx context.Context, req *Request, resp *Respons
 	return err
 }
 
-func (c *PipelineClient) getConnClient() *pipelineConnClient {
+func (c *pipelineConnClient) DoDeadline(ctx context.Context, req *Request, resp *Response, deadline time.Time) error {
+	return c.doDeadline(ctx, nil, req, resp, deadline)
+}
+
+func (c *pipelineConnClient) DoContext(ctx, reqCtx context.Context, req *Request, resp *Response) error {
+	deadline, _ := reqCtx.Deadline()
+	return c.doDeadline(ctx, reqCtx, req, resp, deadline)
+}
+
+// doDeadline queues the request and waits for its response until deadline
+// (if not zero) or until reqCtx (if not nil) is done. Work given up on stays
+// owned by the worker: the writer drops it from the queue and a late response
+// is read into the work's own copy and discarded.
+func (c *pipelineConnClient) doDeadline(ctx, reqCtx context.Context, req *Request, resp *Response, deadline time.Time) error {
+	c.init(ctx)
+
+	var timeout time.Duration
+	if !deadline.IsZero() {
+		if timeout = -time.Since(deadline); timeout <= 0 {
+			return ErrTimeout
+		}
+	}
+	var cancelCh <-chan struct{}
+	if reqCtx != nil {
+		if err := reqCtx.Err(); err != nil {
+			return err
+		}
+		cancelCh = reqCtx.Done()
+	}
+
+	w := acquirePipelineWork(&c.workPool, timeout)
+	// Make a copy of the request in order to avoid data races on timeouts
+	w.reqCopy = *req
+	w.req = &w.reqCopy
+	w.resp = &w.respCopy
+	w.ctx = reqCtx
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		timeoutCh = w.t.C
+	}
+
+	// Put the request to outgoing queue
+	select {
+	case c.chW <- w:
+	default:
+		select {
+		case c.chW <- w:
+		case <-timeoutCh:
+			releasePipelineWork(&c.workPool, w)
+			return ErrTimeout
+		case <-cancelCh:
+			releasePipelineWork(&c.workPool, w)
+			return reqCtx.Err()
+		case <-c.done:
+			releasePipelineWork(&c.workPool, w)
+			return ErrPipelineClientClosed
+		}
+	}
+	c.start()
+
+	// Wait for the response
+	select {
+	case <-w.done:
+		if resp != nil {
+			*resp = w.respCopy
+		}
+		err := w.err
+		releasePipelineWork(&c.workPool, w)
+		return err
+	case <-timeoutCh:
+		return ErrTimeout
+	case <-cancelCh:
+		return reqCtx.Err()
+	case <-c.done:
+		return ErrPipelineClientClosed
+	}
+}
+
+// acquireConnClient returns the connection client for a new call, which must
+// call c.calls.Done when it returns.
+func (c *PipelineClient) acquireConnClient() (*pipelineConnClient, error) {
+	c.connClientsLock.Lock()
+	defer c.connClientsLock.Unlock()
+	if c.closed {
+		return nil, ErrPipelineClientClosed
+	}
+	c.calls.Add(1)
+	return c.getConnClientUnlocked(), nil
+}
+
+// Close stops the client right away: the requests not answered yet fail with
+// ErrPipelineClientClosed, the connections are closed and their goroutines
+// joined.
+func (c *PipelineClient) Close() error {
 	c.connClientsLock.Lock()
-	cc := c.getConnClientUnlocked()
+	c.closed = true
+	connClients := c.connClients
+	for conn := range c.batchConns {
+		conn.Close()
+	}
 	c.connClientsLock.Unlock()
-	return cc
+
+	for _, cc := range connClients {
+		cc.Close()
+	}
+	return nil
+}
+
+// Shutdown stops accepting requests and waits for the ones in progress to be
+// answered before closing the client. If ctx is done first the client is closed
+// anyway, failing the remaining requests, and the context error is returned.
+func (c *PipelineClient) Shutdown(ctx context.Context) error {
+	c.connClientsLock.Lock()
+	c.closed = true
+	c.connClientsLock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		c.calls.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	c.Close()
+	<-done
+	return err
 }
 
 func (c *PipelineClient) getConnClientUnlocked() *pipelineConnClient {
@@ -262,13 +493,13 @@ func (c *PipelineClient) getConnClientUnlocked() *pipelineConnClient {
 
 	// Return the client with the minimum number of pending requests.
 	minCC := c.connClients[0]
-	minReqs := minCC.PendingRequests(c.Ctx)
+	minReqs := minCC.PendingRequests()
 	if minReqs == 0 {
 		return minCC
 	}
 	for i := 1; i < len(c.connClients); i++ {
 		cc := c.connClients[i]
-		reqs := cc.PendingRequests(c.Ctx)
+		reqs := cc.PendingRequests()
 		if reqs == 0 {
 			return cc
 		}
@@ -302,11 +533,24 @@ func (c *PipelineClient) newConnClient() *pipelineConnClient {
 		WriteBufferSize:     c.WriteBufferSize,
 		ReadTimeout:         c.ReadTimeout,
 		WriteTimeout:        c.WriteTimeout,
+		ParseMode:           c.ParseMode,
+		HTTP09Fallback:      c.HTTP09Fallback,
+		Limits:              c.Limits,
+
+		MaxIdemponentCallAttempts: c.MaxIdemponentCallAttempts,
+		RetryIf:                   c.RetryIf,
+
+		desyncs:          &c.desyncs,
+		maxDesyncReports: c.MaxDesyncReports,
 	}
 	c.connClients = append(c.connClients, cc)
 	return cc
 }
 
+func (c *pipelineConnClient) parseOptions() client.ParseOptions {
+	return client.ParseOptions{Mode: c.ParseMode, HTTP09Fallback: c.HTTP09Fallback, Limits: c.Limits}
+}
+
 var ErrPipelineOverflow = errors.New("pipelined requests' queue has been overflown. Increase MaxConns and/or MaxPendingRequests")
 
 const DefaultMaxPendingRequests = 1024
@@ -322,71 +566,213 @@ func (c *pipelineConnClient) init(ctx context.Context) {
 		if c.chW == nil {
 			c.chW = make(chan *pipelineWork, maxPendingRequests)
 		}
-		go func() {
-			// Keep restarting the worker if it fails (connection errors for example).
-			for {
-				if err := c.worker(ctx); err != nil {
-					w := <-c.chW
-					w.err = err
-					w.done <- struct{}{}
-				} else {
-					c.chLock.Lock()
-					stop := len(c.chR) == 0 && len(c.chW) == 0
-					if !stop {
-						c.chR = nil
-						c.chW = nil
-					}
-					c.chLock.Unlock()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		c.ctx, c.stop = context.WithCancel(ctx)
+		c.done = c.ctx.Done()
+	}
+	c.chLock.Unlock()
+}
 
-					if stop {
-						break
-					}
-				}
-			}
-		}()
+// start runs the client unless it is running or stopped. It is called after
+// queueing work, so either run sees the work or it already returned.
+func (c *pipelineConnClient) start() {
+	c.chLock.Lock()
+	defer c.chLock.Unlock()
+	if c.running || c.ctx.Err() != nil {
+		return
+	}
+	c.running = true
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.run(c.ctx)
+	}()
+}
+
+// Close stops the connection client and waits for its goroutines to return.
+// Queued requests fail with ErrPipelineClientClosed.
+func (c *pipelineConnClient) Close() {
+	c.chLock.Lock()
+	if c.stop != nil {
+		c.stop()
 	}
 	c.chLock.Unlock()
+	c.wg.Wait()
+
+	for len(c.chW) > 0 {
+		w := <-c.chW
+		w.err = ErrPipelineClientClosed
+		w.done <- struct{}{}
+	}
+}
+
+// run opens a connection for the queued work, a new one each time the previous
+// connection ends, until ctx is done or the queue is empty once the connection
+// went idle. The next queued work starts it again.
+func (c *pipelineConnClient) run(ctx context.Context) {
+	for {
+		var w *pipelineWork
+		select {
+		case w = <-c.chW:
+		case <-ctx.Done():
+			return
+		default:
+			c.chLock.Lock()
+			idle := len(c.chW) == 0
+			if idle {
+				c.running = false
+			}
+			c.chLock.Unlock()
+			if idle {
+				return
+			}
+			continue
+		}
+		if err := c.worker(ctx, w); err != nil {
+			// the connection couldn't be established
+			w.err = err
+			w.done <- struct{}{}
+		}
+	}
 }
 
-func (c *pipelineConnClient) worker(ctx context.Context) error {
+// worker serves the queued work on a new connection, starting with first, until
+// the connection ends. The work failed by a connection error that can be
+// retried is queued again for the next connection.
+func (c *pipelineConnClient) worker(ctx context.Context, first *pipelineWork) error {
 	tlsConfig := c.cachedTLSConfig()
 	conn, err := dialAddr(ctx, c.Addr, c.Dial, c.DialDualStack, c.IsTLS, tlsConfig, c.WriteTimeout)
 	if err != nil {
 		return err
 	}
+	var retries retryQueue
+
+	d := newConnDesync(conn)
+	c.desyncs.add(d, c.maxDesyncReports)
 
 	// Start reader and writer
 	stopW := make(chan struct{})
 	doneW := make(chan error)
 	go func() {
-		doneW <- c.writer(conn, stopW)
+		doneW <- c.writer(conn, d, first, &retries, stopW)
 	}()
 	stopR := make(chan struct{})
 	doneR := make(chan error)
 	go func() {
-		doneR <- c.reader(conn, stopR)
+		doneR <- c.reader(conn, d, &retries, stopR)
 	}()
 
 	// Wait until reader and writer are stopped
 	select {
-	case err = <-doneW:
+	case <-doneW:
 		conn.Close()
 		close(stopR)
 		<-doneR
-	case err = <-doneR:
+	case <-doneR:
 		conn.Close()
 		close(stopW)
 		<-doneW
+	case <-ctx.Done():
+		conn.Close()
+		close(stopW)
+		close(stopR)
+		<-doneW
+		<-doneR
 	}
+	d.close()
 
-	// Notify pending readers
+	// Notify pending readers. The requests written after the server asked to
+	// close were not processed, they are sent again on a new connection unless
+	// expired. The others failed by the connection are only if they can be
+	// retried.
 	for len(c.chR) > 0 {
-		w := <-c.chR
-		w.err = errPipelineConnStopped
+		c.fail(<-c.chR, errPipelineConnStopped, &retries)
+	}
+	closeAfter := d.closeAfter()
+	for _, w := range retries.works {
+		switch {
+		case ctx.Err() != nil:
+			w.err = ErrPipelineClientClosed
+		case closeAfter > 0 && w.seq > closeAfter:
+			// not a retry, the attempt is given back
+			w.attempts--
+			w.err = w.expiredErr()
+		case c.canRetry(w, w.err):
+			w.err = nil
+		}
+		if w.err == nil {
+			select {
+			case c.chW <- w:
+				continue
+			default:
+				w.err = ErrPipelineOverflow
+			}
+		}
 		w.done <- struct{}{}
 	}
 
-	return err
+	return nil
+}
+
+// retryQueue holds the work failed by the connection, sent again or ended once
+// the connection is torn down.
+type retryQueue struct {
+	mu    sync.Mutex
+	works []*pipelineWork
+}
+
+func (q *retryQueue) add(w *pipelineWork) {
+	q.mu.Lock()
+	q.works = append(q.works, w)
+	q.mu.Unlock()
+}
+
+// fail ends w with err. Work failed by the connection is set aside in retries
+// instead, whether it is sent again being known once the connection is torn down.
+func (c *pipelineConnClient) fail(w *pipelineWork, err error, retries *retryQueue) {
+	w.err = err
+	if isConnErr(err) {
+		retries.add(w)
+		return
+	}
+	w.done <- struct{}{}
+}
+
+func (c *pipelineConnClient) canRetry(w *pipelineWork, err error) bool {
+	if !isConnErr(err) || w.expiredErr() != nil {
+		return false
+	}
+	maxAttempts := c.MaxIdemponentCallAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxIdemponentCallAttempts
+	}
+	if w.attempts >= maxAttempts {
+		return false
+	}
+	return isIdempotent(w.req.method()) || (c.RetryIf != nil && c.RetryIf(w.req))
+}
+
+// isConnErr reports whether err comes from the connection itself rather than
+// from the response read on it
+func isConnErr(err error) bool {
+	if isTimeoutErr(err) {
+		return false
+	}
+	var opErr *net.OpError
+	return errors.Is(err, errPipelineConnStopped) || errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) || errors.As(err, &opErr)
+}
+
+// isIdempotent reports whether requests with method can safely be sent again,
+// rfc 9110 s9.2.2
+func isIdempotent(method string) bool {
+	switch strings.ToUpper(method) {
+	case "GET", "HEAD", "PUT", "DELETE", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
 }
 
 func (c *pipelineConnClient) cachedTLSConfig() *tls.Config {
@@ -405,12 +791,12 @@ func (c *pipelineConnClient) cachedTLSConfig() *tls.Config {
 	return cfg
 }
 
-func (c *pipelineConnClient) writer(conn net.Conn, stopCh <-chan struct{}) error {
+func (c *pipelineConnClient) writer(conn net.Conn, d *connDesync, first *pipelineWork, retries *retryQueue, stopCh <-chan struct{}) error {
 	writeBufferSize := c.WriteBufferSize
 	if writeBufferSize <= 0 {
 		writeBufferSize = defaultWriteBufferSize
 	}
-	bw := bufio.NewWriterSize(conn, writeBufferSize)
+	bw := bufio.NewWriterSize(countingWriter{w: conn, n: &d.flushed}, writeBufferSize)
 	defer bw.Flush()
 	chR := c.chR
 	chW := c.chW
@@ -428,36 +814,40 @@ func (c *pipelineConnClient) writer(conn net.Conn, stopCh <-chan struct{}) error
 		flushTimerCh   <-chan time.Time
 		instantTimerCh = make(chan time.Time)
 
-		w   *pipelineWork
+		w   = first
 		err error
 	)
 	close(instantTimerCh)
 	for {
-	againChW:
-		select {
-		case w = <-chW:
-			// Fast path: len(chW) > 0
-		default:
-			// Slow path
-			stopTimer.Reset(maxIdleConnDuration)
+		if w == nil {
+		againChW:
 			select {
 			case w = <-chW:
-			case <-stopTimer.C:
-				return nil
-			case <-stopCh:
-				return nil
-			case <-flushTimerCh:
-				if err = bw.Flush(); err != nil {
-					return err
+				// Fast path: len(chW) > 0
+			default:
+				// Slow path
+				stopTimer.Reset(maxIdleConnDuration)
+				select {
+				case w = <-chW:
+				case <-stopTimer.C:
+					return nil
+				case <-stopCh:
+					return nil
+				case <-flushTimerCh:
+					if err = bw.Flush(); err != nil {
+						return err
+					}
+					flushTimerCh = nil
+					goto againChW
 				}
-				flushTimerCh = nil
-				goto againChW
 			}
 		}
 
-		if !w.deadline.IsZero() && time.Since(w.deadline) >= 0 {
-			w.err = ErrTimeout
+		// Drop work whose caller already gave up
+		if err := w.expiredErr(); err != nil {
+			w.err = err
 			w.done <- struct{}{}
+			w = nil
 			continue
 		}
 
@@ -471,11 +861,14 @@ func (c *pipelineConnClient) writer(conn net.Conn, stopCh <-chan struct{}) error
 				return err
 			}
 		}
+		w.attempts++
+		w.resp.Attempts = w.attempts
+		w.seq = d.written.Add(1)
 		if err = w.req.Write(bw); err != nil {
-			w.err = err
-			w.done <- struct{}{}
+			c.fail(w, err, retries)
 			return err
 		}
+		w.end = d.flushed.Load() + int64(bw.Buffered())
 		if flushTimerCh == nil && (len(chW) == 0 || len(chR) == cap(chR)) {
 			if maxBatchDelay > 0 {
 				flushTimer.Reset(maxBatchDelay)
@@ -494,23 +887,22 @@ func (c *pipelineConnClient) writer(conn net.Conn, stopCh <-chan struct{}) error
 			select {
 			case chR <- w:
 			case <-stopCh:
-				w.err = errPipelineConnStopped
-				w.done <- struct{}{}
+				c.fail(w, errPipelineConnStopped, retries)
 				return nil
 			case <-flushTimerCh:
 				if err = bw.Flush(); err != nil {
-					w.err = err
-					w.done <- struct{}{}
+					c.fail(w, err, retries)
 					return err
 				}
 				flushTimerCh = nil
 				goto againChR
 			}
 		}
+		w = nil
 	}
 }
 
-func (c *pipelineConnClient) reader(conn net.Conn, stopCh <-chan struct{}) error {
+func (c *pipelineConnClient) reader(conn net.Conn, d *connDesync, retries *retryQueue, stopCh <-chan struct{}) error {
 	readBufferSize := c.ReadBufferSize
 	if readBufferSize <= 0 {
 		readBufferSize = defaultReadBufferSize
@@ -546,37 +938,95 @@ func (c *pipelineConnClient) reader(conn net.Conn, stopCh <-chan struct{}) error
 				return err
 			}
 		}
-		if err = w.resp.Read(br); err != nil {
-			w.err = err
-			w.done <- struct{}{}
+		if _, err = br.Peek(1); err == nil && d.flushed.Load() < w.end {
+			d.anomaly(DesyncEarlyResponse, w.seq, nil, "response started before the request was flushed")
+		}
+		w.resp.Proxy = connProxy(conn)
+		w.resp.ConnID = d.id
+		w.resp.Seq = w.seq
+		if err = w.resp.read(br, w.req.method(), c.parseOptions()); err != nil {
+			d.readerStopped(err)
+			c.fail(w, err, retries)
 			return err
 		}
+		d.answered(w.seq)
+		if n := br.Buffered(); n > 0 && d.written.Load() == w.seq {
+			extra, _ := br.Peek(n)
+			d.anomaly(DesyncExtraBytes, w.seq, append([]byte(nil), extra...), fmt.Sprintf("%d bytes after the response", n))
+		}
+		closesConn := w.resp.closesConn()
+		if closesConn {
+			d.closeRequested(w.seq)
+		}
+		if w.resp.closeDelimited {
+			// the response ended with the connection
+			d.readerStopped(nil)
+		}
 
 		w.done <- struct{}{}
+
+		if closesConn {
+			// the server doesn't read further requests, stop here so the ones
+			// written after this one are sent again on a new connection
+			return errConnCloseRequested
+		}
+	}
+}
+
+// connProxy returns the proxy reported by conn, or by the connection wrapped by tls
+func connProxy(conn net.Conn) string {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if pc, ok := conn.(interface{ Proxy() string }); ok {
+		return pc.Proxy()
 	}
+	return ""
 }
 
 func (c *PipelineClient) PendingRequests() int {
 	c.connClientsLock.Lock()
 	n := 0
 	for _, cc := range c.connClients {
-		n += cc.PendingRequests(c.Ctx)
+		n += cc.PendingRequests()
 	}
 	c.connClientsLock.Unlock()
 	return n
 }
 
-func (c *pipelineConnClient) PendingRequests(ctx context.Context) int {
-	c.init(ctx)
-
+func (c *pipelineConnClient) PendingRequests() int {
 	c.chLock.Lock()
 	n := len(c.chR) + len(c.chW)
 	c.chLock.Unlock()
 	return n
 }
 
+// DesyncReports returns the desync reports of the last MaxDesyncReports
+// connections opened, by DoBatch included, in the order they were opened.
+func (c *PipelineClient) DesyncReports() []DesyncReport {
+	return c.desyncs.snapshot()
+}
+
+// RequestsPerConn returns the lowest number of requests the server answered on
+// a connection before closing it, 0 if it never closed one.
+func (c *PipelineClient) RequestsPerConn() uint64 {
+	var n uint64
+	for _, r := range c.DesyncReports() {
+		if r.CloseAfter > 0 && (n == 0 || r.CloseAfter < n) {
+			n = r.CloseAfter
+		}
+	}
+	return n
+}
+
 var errPipelineConnStopped = errors.New("pipeline connection has been stopped")
 
+var errConnCloseRequested = errors.New("server closes the connection")
+
+// ErrPipelineClientClosed is returned for requests made or pending after the
+// client was closed.
+var ErrPipelineClientClosed = errors.New("pipeline client has been closed")
+
 func acquirePipelineWork(pool *sync.Pool, timeout time.Duration) *pipelineWork {
 	v := pool.Get()
 	if v == nil {
@@ -598,11 +1048,22 @@ func acquirePipelineWork(pool *sync.Pool, timeout time.Duration) *pipelineWork {
 }
 
 func releasePipelineWork(pool *sync.Pool, w *pipelineWork) {
-	if w.t != nil {
-		w.t.Stop()
+	if w.t != nil && !w.t.Stop() {
+		// drain a fired timer so the next Reset doesn't see a stale tick
+		select {
+		case <-w.t.C:
+		default:
+		}
 	}
+	w.reqCopy = Request{}
+	w.respCopy = Response{}
 	w.req = nil
 	w.resp = nil
+	w.deadline = time.Time{}
+	w.ctx = nil
+	w.attempts = 0
+	w.seq = 0
+	w.end = 0
 	w.err = nil
 	pool.Put(w)
 }
@@ -0,0 +1,676 @@
+package clientpipeline
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/secoba/rawhttp/client"
+	"github.com/stretchr/testify/require"
+)
+
+// pipeServer returns a PipelineClient connected through PipeConns to a stand-in
+// server answering every request with the raw response returned by handle. The
+// server closes the connection after the response if closeConn is true. The
+// client is closed when the test ends.
+func pipeServer(t *testing.T, handle func(req *http.Request) (resp string, closeConn bool)) *PipelineClient {
+	c := &PipelineClient{
+		Ctx:  context.Background(),
+		Addr: "pipe",
+		Dial: func(ctx context.Context, addr string) (net.Conn, error) {
+			pc := NewPipeConns()
+			t.Cleanup(func() { pc.Close() })
+			go func() {
+				conn := pc.Conn2()
+				br := bufio.NewReader(conn)
+				for {
+					req, err := http.ReadRequest(br)
+					if err != nil {
+						return
+					}
+					_, _ = io.Copy(io.Discard, req.Body)
+					resp, closeConn := handle(req)
+					if _, err := conn.Write([]byte(resp)); err != nil || closeConn {
+						conn.Close()
+						return
+					}
+				}
+			}()
+			return pc.Conn1(), nil
+		},
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func okResponse(body string) string {
+	return fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func newRequest(path string) *Request {
+	return &Request{Method: "GET", Path: path, Version: HTTP_1_1, Headers: []Header{{Key: "Host", Value: "pipe"}}}
+}
+
+func readBody(t *testing.T, resp *Response) string {
+	body, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+	return string(body)
+}
+
+func TestPipelineClientDoTimeout(t *testing.T) {
+	c := pipeServer(t, func(req *http.Request) (string, bool) {
+		if req.URL.Path == "/slow" {
+			time.Sleep(300 * time.Millisecond)
+		}
+		return okResponse(req.URL.Path), false
+	})
+
+	var resp Response
+	start := time.Now()
+	err := c.DoTimeout(newRequest("/slow"), &resp, 50*time.Millisecond)
+	require.Equal(t, ErrTimeout, err)
+	require.Less(t, time.Since(start), 250*time.Millisecond)
+
+	// the late response of /slow must be discarded, not handed to the next request
+	require.Nil(t, c.DoTimeout(newRequest("/fast"), &resp, 5*time.Second))
+	require.Equal(t, "/fast", readBody(t, &resp))
+
+	require.Equal(t, ErrTimeout, c.DoDeadline(newRequest("/fast"), &resp, time.Now().Add(-time.Second)))
+}
+
+func TestPipelineClientDoContext(t *testing.T) {
+	c := pipeServer(t, func(req *http.Request) (string, bool) {
+		if req.URL.Path == "/slow" {
+			time.Sleep(300 * time.Millisecond)
+		}
+		return okResponse(req.URL.Path), false
+	})
+
+	var resp Response
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	require.Equal(t, context.Canceled, c.DoContext(ctx, newRequest("/slow"), &resp))
+	require.Equal(t, context.Canceled, c.DoContext(ctx, newRequest("/fast"), &resp))
+
+	require.Nil(t, c.DoContext(context.Background(), newRequest("/fast"), &resp))
+	require.Equal(t, "/fast", readBody(t, &resp))
+}
+
+func TestPipelineClientFraming(t *testing.T) {
+	responses := map[string]string{
+		"/chunked":  "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Checksum\r\n\r\n4;ext=1\r\n/chu\r\n4\r\nnked\r\n0\r\nX-Checksum: abc\r\n\r\n",
+		"/length":   okResponse("/length"),
+		"/empty":    "HTTP/1.1 204 No Content\r\nContent-Length: 10\r\n\r\n",
+		"/cached":   "HTTP/1.1 304 Not Modified\r\nTransfer-Encoding: chunked\r\n\r\n",
+		"/head":     "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n",
+		"/continue": "HTTP/1.1 100 Continue\r\n\r\n" + okResponse("/continue"),
+		"/close":    "HTTP/1.1 200 OK\r\n\r\n/close until the end",
+		"/both":     "HTTP/1.1 200 OK\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n5\r\n/both\r\n0\r\n\r\n",
+		"/case":     "HTTP/1.1 200 OK\r\nTransfer-Encoding: Chunked\r\n\r\n5\r\n/case\r\n0\r\n\r\n",
+		"/case-cl":  "HTTP/1.1 200 OK\r\nContent-Length: 3\r\nTransfer-Encoding: Chunked\r\n\r\n8\r\n/case-cl\r\n0\r\n\r\n",
+		"/gzip":     "HTTP/1.1 200 OK\r\nTransfer-Encoding: gzip, chunked\r\n\r\n5\r\n/gzip\r\n0\r\n\r\n",
+		"/gzip-cl":  "HTTP/1.1 200 OK\r\nTransfer-Encoding: gzip\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n8\r\n/gzip-cl\r\n0\r\n\r\n",
+	}
+	bodies := map[string]string{
+		"/both":     "/both",
+		"/case":     "/case",
+		"/case-cl":  "/case-cl",
+		"/gzip":     "/gzip",
+		"/gzip-cl":  "/gzip-cl",
+		"/chunked":  "/chunked",
+		"/length":   "/length",
+		"/continue": "/continue",
+	}
+	c := pipeServer(t, func(req *http.Request) (string, bool) {
+		return responses[req.URL.Path], req.URL.Path == "/close"
+	})
+	c.MaxConns = 1
+
+	// pipeline the framed responses on the same connection
+	var wg sync.WaitGroup
+	do := func(req *Request, code int, body string) {
+		defer wg.Done()
+		var resp Response
+		if err := c.DoTimeout(req, &resp, 5*time.Second); err != nil {
+			t.Errorf("%s: %v", req.Path, err)
+			return
+		}
+		got, err := io.ReadAll(resp.Body)
+		if err != nil || resp.Status.Code != code || string(got) != body {
+			t.Errorf("%s: got %d %q %v, want %d %q", req.Path, resp.Status.Code, got, err, code, body)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		for path := range bodies {
+			wg.Add(1)
+			go do(newRequest(path), 200, bodies[path])
+		}
+		wg.Add(3)
+		go do(newRequest("/empty"), 204, "")
+		go do(newRequest("/cached"), 304, "")
+		head := newRequest("/head")
+		head.Method = "HEAD"
+		go do(head, 200, "")
+	}
+	wg.Wait()
+
+	var resp Response
+	require.Nil(t, c.DoTimeout(newRequest("/chunked"), &resp, 5*time.Second))
+	require.Equal(t, []Header{{Key: "X-Checksum", Value: "abc"}}, resp.Trailers)
+	require.Equal(t, []client.Chunk{{Size: 4, Extensions: "ext=1"}, {Size: 4}, {Size: 0}}, resp.Chunks)
+	require.Equal(t, "4;ext=1\r\n/chu\r\n4\r\nnked\r\n0\r\nX-Checksum: abc\r\n\r\n", string(resp.RawChunked))
+
+	// Transfer-Encoding wins over Content-Length, the conflict being reported
+	require.Nil(t, c.DoTimeout(newRequest("/both"), &resp, 5*time.Second))
+	require.Equal(t, "/both", readBody(t, &resp))
+	require.Len(t, resp.Anomalies, 1)
+	require.Equal(t, client.AnomalyContentLengthWithTransferEncoding, resp.Anomalies[0].Kind)
+
+	require.Nil(t, c.DoTimeout(newRequest("/gzip-cl"), &resp, 5*time.Second))
+	require.Equal(t, "chunked", resp.TransferEncoding())
+	require.Len(t, resp.Anomalies, 2)
+	require.Equal(t, client.AnomalyTransferEncoding, resp.Anomalies[0].Kind)
+	require.Equal(t, "Transfer-Encoding: gzip\r\nTransfer-Encoding: chunked", resp.Anomalies[0].Line)
+	require.Equal(t, client.AnomalyContentLengthWithTransferEncoding, resp.Anomalies[1].Kind)
+
+	require.Nil(t, c.DoTimeout(newRequest("/close"), &resp, 5*time.Second))
+	require.Equal(t, "/close until the end", readBody(t, &resp))
+
+	// chunked not last, the body is delimited by the close whatever Content-Length says
+	c = pipeServer(t, func(req *http.Request) (string, bool) {
+		return "HTTP/1.1 200 OK\r\nContent-Length: 3\r\nTransfer-Encoding: chunked, gzip\r\n\r\n/not-last", true
+	})
+	require.Nil(t, c.DoTimeout(newRequest("/"), &resp, 5*time.Second))
+	require.Equal(t, "gzip", resp.TransferEncoding())
+	require.Equal(t, "/not-last", readBody(t, &resp))
+}
+
+func TestPipelineClientDesyncReports(t *testing.T) {
+	c := pipeServer(t, func(req *http.Request) (string, bool) {
+		switch req.URL.Path {
+		case "/smuggle":
+			return okResponse("/smuggle") + okResponse("extra"), false
+		case "/drop":
+			return "", true
+		}
+		return okResponse(req.URL.Path), false
+	})
+	c.MaxConns = 1
+
+	var resp Response
+	require.Nil(t, c.DoTimeout(newRequest("/a"), &resp, 5*time.Second))
+	require.Equal(t, uint64(1), resp.Seq)
+	require.Nil(t, c.DoTimeout(newRequest("/smuggle"), &resp, 5*time.Second))
+	require.Equal(t, uint64(2), resp.Seq)
+	connID := resp.ConnID
+
+	reports := c.DesyncReports()
+	require.Len(t, reports, 1)
+	require.Equal(t, connID, reports[0].ConnID)
+	require.Equal(t, uint64(2), reports[0].Written)
+	require.Equal(t, uint64(2), reports[0].Answered)
+	require.Len(t, reports[0].Anomalies, 1)
+	require.Equal(t, DesyncExtraBytes, reports[0].Anomalies[0].Kind)
+	require.Equal(t, uint64(2), reports[0].Anomalies[0].Seq)
+	require.Equal(t, okResponse("extra"), string(reports[0].Anomalies[0].Data))
+
+	c = pipeServer(t, func(req *http.Request) (string, bool) {
+		return "", true
+	})
+	require.NotNil(t, c.DoTimeout(newRequest("/drop"), &resp, 5*time.Second))
+	// one connection per attempt
+	reports = c.DesyncReports()
+	require.Len(t, reports, DefaultMaxIdemponentCallAttempts)
+	for _, r := range reports {
+		require.Len(t, r.Anomalies, 1)
+		require.Equal(t, DesyncEarlyClose, r.Anomalies[0].Kind)
+		require.Equal(t, uint64(1), r.Anomalies[0].Seq)
+	}
+
+	// a response read while the write of its request has yet to return is no
+	// early response
+	c = pipeServer(t, func(req *http.Request) (string, bool) {
+		return okResponse(req.URL.Path), false
+	})
+	dial := c.Dial
+	c.Dial = func(ctx context.Context, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, addr)
+		return slowWriteConn{conn}, err
+	}
+	require.Nil(t, c.DoTimeout(newRequest("/slow-write"), &resp, 5*time.Second))
+	reports = c.DesyncReports()
+	require.Len(t, reports, 1)
+	require.Empty(t, reports[0].Anomalies)
+}
+
+// slowWriteConn returns from Write long after the bytes were written
+type slowWriteConn struct {
+	net.Conn
+}
+
+func (c slowWriteConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	time.Sleep(50 * time.Millisecond)
+	return n, err
+}
+
+func TestPipelineClientMaxDesyncReports(t *testing.T) {
+	c := pipeServer(t, func(req *http.Request) (string, bool) {
+		return okResponse(req.URL.Path), false
+	})
+	c.MaxDesyncReports = 2
+
+	var resp Response
+	require.Nil(t, c.DoTimeout(newRequest("/a"), &resp, 5*time.Second))
+	var connIDs []uint64
+	for i := 0; i < 3; i++ {
+		resps, err := c.DoBatch([]*Request{newRequest("/batch")})
+		require.Nil(t, err)
+		connIDs = append(connIDs, resps[0].ConnID)
+	}
+
+	// the pipelined connection and the first batch are dropped
+	reports := c.DesyncReports()
+	require.Len(t, reports, 2)
+	require.Equal(t, connIDs[1:], []uint64{reports[0].ConnID, reports[1].ConnID})
+}
+
+func TestPipelineClientDoBatch(t *testing.T) {
+	firstRead := make(chan string, 1)
+	c := &PipelineClient{
+		Ctx:  context.Background(),
+		Addr: "pipe",
+		Dial: func(ctx context.Context, addr string) (net.Conn, error) {
+			pc := NewPipeConns()
+			t.Cleanup(func() { pc.Close() })
+			go func() {
+				conn := pc.Conn2()
+				buf := make([]byte, 64*1024)
+				n, err := conn.Read(buf)
+				if err != nil {
+					return
+				}
+				firstRead <- string(buf[:n])
+				_, _ = conn.Write([]byte(okResponse("/a") + okResponse("/b")))
+				conn.Close()
+			}()
+			return pc.Conn1(), nil
+		},
+	}
+
+	resps, err := c.DoBatch([]*Request{newRequest("/a"), newRequest("/b"), newRequest("/c")})
+	require.ErrorIs(t, err, io.EOF)
+	require.Len(t, resps, 2)
+	require.Equal(t, "/a", readBody(t, resps[0]))
+	require.Equal(t, "/b", readBody(t, resps[1]))
+	require.Equal(t, uint64(2), resps[1].Seq)
+
+	// all the requests went out in the same write
+	written := <-firstRead
+	for _, path := range []string{"/a", "/b", "/c"} {
+		require.Contains(t, written, "GET "+path+" HTTP/1.1\r\n")
+	}
+
+	reports := c.DesyncReports()
+	require.Len(t, reports, 1)
+	require.Equal(t, uint64(3), reports[0].Written)
+	require.Equal(t, DesyncEarlyClose, reports[0].Anomalies[0].Kind)
+	require.Equal(t, uint64(3), reports[0].Anomalies[0].Seq)
+}
+
+func TestPipelineClientRetries(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls = map[string]int{}
+	)
+	newClient := func() *PipelineClient {
+		return pipeServer(t, func(req *http.Request) (string, bool) {
+			mu.Lock()
+			defer mu.Unlock()
+			key := req.Method + " " + req.URL.Path
+			calls[key]++
+			if calls[key] <= 2 {
+				// drop the connection without answering
+				return "", true
+			}
+			return okResponse(key), false
+		})
+	}
+
+	// idempotent requests are retried by default
+	c := newClient()
+	var resp Response
+	require.Nil(t, c.DoTimeout(newRequest("/get"), &resp, 5*time.Second))
+	require.Equal(t, "GET /get", readBody(t, &resp))
+	require.Equal(t, 3, resp.Attempts)
+
+	post := newRequest("/post")
+	post.Method = "POST"
+	require.NotNil(t, c.DoTimeout(post, &resp, 5*time.Second))
+	require.Equal(t, 1, calls["POST /post"])
+
+	c = newClient()
+	c.MaxIdemponentCallAttempts = 2
+	require.NotNil(t, c.DoTimeout(newRequest("/again"), &resp, 5*time.Second))
+	require.Equal(t, 2, resp.Attempts)
+
+	// RetryIf adds to the idempotent requests retried
+	c = newClient()
+	c.RetryIf = func(req *Request) bool { return req.Method == "POST" }
+	require.Nil(t, c.DoTimeout(post, &resp, 5*time.Second))
+	require.Equal(t, 2, resp.Attempts)
+	require.Equal(t, "POST /post", readBody(t, &resp))
+	require.Nil(t, c.DoTimeout(newRequest("/get-again"), &resp, 5*time.Second))
+	require.Equal(t, 3, resp.Attempts)
+}
+
+func TestPipelineClientClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	c := pipeServer(t, func(req *http.Request) (string, bool) {
+		if req.URL.Path == "/slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		return okResponse(req.URL.Path), false
+	})
+	c.MaxConns = 2
+
+	// Shutdown waits for the request in flight
+	slow := make(chan error, 1)
+	var slowResp Response
+	go func() {
+		slow <- c.DoTimeout(newRequest("/slow"), &slowResp, 5*time.Second)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	require.Nil(t, c.Shutdown(context.Background()))
+	require.Nil(t, <-slow)
+	require.Equal(t, "/slow", readBody(t, &slowResp))
+
+	var resp Response
+	require.Equal(t, ErrPipelineClientClosed, c.DoTimeout(newRequest("/fast"), &resp, time.Second))
+	_, err := c.DoBatch([]*Request{newRequest("/fast")})
+	require.Equal(t, ErrPipelineClientClosed, err)
+
+	// Shutdown gives up on the request in flight once ctx is done
+	c = pipeServer(t, func(req *http.Request) (string, bool) {
+		time.Sleep(300 * time.Millisecond)
+		return okResponse(req.URL.Path), false
+	})
+	go func() {
+		slow <- c.Do(newRequest("/slow"), &resp)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, c.Shutdown(ctx))
+	require.Equal(t, ErrPipelineClientClosed, <-slow)
+
+	// every goroutine of the clients and of their stand-in servers is gone
+	// (polling here, require.Eventually runs its own goroutines)
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
+
+func TestPipelineClientIdle(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	c := pipeServer(t, func(req *http.Request) (string, bool) {
+		return okResponse(req.URL.Path), false
+	})
+	c.MaxIdleConnDuration = 20 * time.Millisecond
+
+	var resp Response
+	require.Nil(t, c.DoTimeout(newRequest("/a"), &resp, 5*time.Second))
+
+	// without Close, the goroutines end once the connection went idle
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.LessOrEqual(t, runtime.NumGoroutine(), before)
+
+	// and start again with the next request
+	require.Nil(t, c.DoTimeout(newRequest("/b"), &resp, 5*time.Second))
+	require.Equal(t, "/b", readBody(t, &resp))
+}
+
+// closingServer returns a PipelineClient whose connections answer two requests,
+// the second with last, ignore the requests after it and close. It reports the
+// requests received by method and path.
+func closingServer(t *testing.T, last func(path string) string) (*PipelineClient, func() map[string]int) {
+	var (
+		mu    sync.Mutex
+		calls = map[string]int{}
+	)
+	c := &PipelineClient{
+		Ctx:  context.Background(),
+		Addr: "pipe",
+		Dial: func(ctx context.Context, addr string) (net.Conn, error) {
+			pc := NewPipeConns()
+			t.Cleanup(func() { pc.Close() })
+			go func() {
+				conn := pc.Conn2()
+				br := bufio.NewReader(conn)
+				for served := 1; ; served++ {
+					req, err := http.ReadRequest(br)
+					if err != nil {
+						return
+					}
+					mu.Lock()
+					calls[req.Method+" "+req.URL.Path]++
+					mu.Unlock()
+					if served == 2 {
+						_, _ = conn.Write([]byte(last(req.URL.Path)))
+						time.Sleep(10 * time.Millisecond)
+						conn.Close()
+						return
+					}
+					_, _ = conn.Write([]byte(okResponse(req.URL.Path)))
+				}
+			}()
+			return pc.Conn1(), nil
+		},
+	}
+	t.Cleanup(func() { c.Close() })
+	return c, func() map[string]int {
+		mu.Lock()
+		defer mu.Unlock()
+		received := make(map[string]int, len(calls))
+		for k, v := range calls {
+			received[k] = v
+		}
+		return received
+	}
+}
+
+func TestPipelineClientCloseRequested(t *testing.T) {
+	closeResponse := func(path string) string {
+		return "HTTP/1.1 200 OK\r\nConnection: close\r\nContent-Length: " + fmt.Sprint(len(path)) + "\r\n\r\n" + path
+	}
+
+	// the requests written after the close are sent again with the default
+	// settings, not being retries
+	c, _ := closingServer(t, closeResponse)
+	errs := make(chan error, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			var resp Response
+			if err := c.DoTimeout(newRequest(path), &resp, 5*time.Second); err != nil {
+				errs <- err
+				return
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err == nil && string(body) != path {
+				err = fmt.Errorf("got body %q for %s", body, path)
+			}
+			if err != nil {
+				errs <- err
+			}
+		}(fmt.Sprintf("/%d", i))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	require.Equal(t, uint64(2), c.RequestsPerConn())
+	for _, r := range c.DesyncReports() {
+		require.Empty(t, r.Anomalies)
+	}
+
+	// whatever their method, the server never processed them
+	c, calls := closingServer(t, closeResponse)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			req := newRequest(path)
+			req.Method = "POST"
+			var resp Response
+			if err := c.DoTimeout(req, &resp, 5*time.Second); err != nil {
+				t.Errorf("%s: %v", path, err)
+			} else if resp.Attempts != 1 {
+				t.Errorf("%s: %d attempts", path, resp.Attempts)
+			}
+		}(fmt.Sprintf("/%d", i))
+	}
+	wg.Wait()
+	received := calls()
+	require.Len(t, received, 10)
+	for key, n := range received {
+		require.Equal(t, 1, n, key)
+	}
+}
+
+func TestPipelineClientCloseDelimited(t *testing.T) {
+	c, _ := closingServer(t, func(path string) string {
+		return "HTTP/1.1 200 OK\r\n\r\n" + path
+	})
+	c.MaxConns = 1
+	c.MaxBatchDelay = 50 * time.Millisecond
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			_ = c.DoTimeout(newRequest(path), nil, 5*time.Second)
+		}(fmt.Sprintf("/%d", i))
+	}
+	wg.Wait()
+
+	reports := c.DesyncReports()
+	require.NotEmpty(t, reports)
+	require.Equal(t, uint64(3), reports[0].Written)
+	require.Len(t, reports[0].Anomalies, 1)
+	require.Equal(t, DesyncEarlyClose, reports[0].Anomalies[0].Kind)
+	require.Equal(t, uint64(3), reports[0].Anomalies[0].Seq)
+}
+
+func TestPipelineClientParseMode(t *testing.T) {
+	malformed := "HTTP/1.1 200 OK\r\nContent-Length : 2\r\nX-A: a\r\n b\r\n\r\nok"
+	c := pipeServer(t, func(req *http.Request) (string, bool) {
+		return malformed, false
+	})
+	var resp Response
+	require.Nil(t, c.DoTimeout(newRequest("/"), &resp, 5*time.Second))
+	require.Equal(t, "ok", readBody(t, &resp))
+	require.Equal(t, []Header{{"Content-Length", "2"}, {"X-A", "a b"}}, resp.Headers)
+	require.Len(t, resp.Anomalies, 2)
+	require.Equal(t, client.AnomalyWhitespaceBeforeColon, resp.Anomalies[0].Kind)
+	require.Equal(t, client.AnomalyObsFold, resp.Anomalies[1].Kind)
+
+	c = pipeServer(t, func(req *http.Request) (string, bool) {
+		return malformed, false
+	})
+	c.ParseMode = client.Strict
+	var anomalyErr *client.AnomalyError
+	err := c.DoTimeout(newRequest("/"), &Response{}, 5*time.Second)
+	require.ErrorAs(t, err, &anomalyErr)
+	require.Equal(t, client.AnomalyWhitespaceBeforeColon, anomalyErr.Kind)
+}
+
+func TestPipelineClientHTTP09Fallback(t *testing.T) {
+	banner := "220 smtp.example ESMTP\r\n"
+	c := pipeServer(t, func(req *http.Request) (string, bool) {
+		return banner, true
+	})
+	var nonHTTP *client.NonHTTPError
+	err := c.DoTimeout(newRequest("/"), &Response{}, 5*time.Second)
+	require.ErrorAs(t, err, &nonHTTP)
+	require.Equal(t, banner, string(nonHTTP.Banner))
+
+	c = pipeServer(t, func(req *http.Request) (string, bool) {
+		return banner, true
+	})
+	c.HTTP09Fallback = true
+	var resp Response
+	require.Nil(t, c.DoTimeout(newRequest("/"), &resp, 5*time.Second))
+	require.Equal(t, 0, resp.Version.Major)
+	require.Equal(t, 9, resp.Version.Minor)
+	require.Equal(t, banner, readBody(t, &resp))
+}
+
+func TestPipelineClientLimits(t *testing.T) {
+	c := pipeServer(t, func(req *http.Request) (string, bool) {
+		return "HTTP/1.1 200 OK\r\nX-A: a\r\nX-B: b\r\nX-C: c\r\nContent-Length: 0\r\n\r\n", false
+	})
+	c.Limits.MaxHeaderCount = 2
+	var limitErr *client.LimitError
+	err := c.DoTimeout(newRequest("/"), &Response{}, 5*time.Second)
+	require.ErrorAs(t, err, &limitErr)
+	require.Equal(t, client.LimitHeaderCount, limitErr.Kind)
+	require.Equal(t, []client.Header{{Key: "X-A", Value: "a"}, {Key: "X-B", Value: "b"}}, limitErr.Headers)
+}
+
+func TestPipelineClientBodyLength(t *testing.T) {
+	responses := map[string]string{
+		// the announced length is not allocated up front
+		"/huge":    "HTTP/1.1 200 OK\r\nContent-Length: 1000000000000000\r\n\r\nshort",
+		"/length":  okResponse("0123456789"),
+		"/chunked": "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\na\r\n0123456789\r\n0\r\n\r\n",
+		"/close":   "HTTP/1.1 200 OK\r\n\r\n0123456789",
+	}
+	newClient := func() *PipelineClient {
+		return pipeServer(t, func(req *http.Request) (string, bool) {
+			path := req.URL.Path
+			return responses[path], path == "/huge" || path == "/close"
+		})
+	}
+
+	c := newClient()
+	err := c.DoTimeout(newRequest("/huge"), &Response{}, 5*time.Second)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	var limitErr *client.LimitError
+	for _, path := range []string{"/huge", "/length", "/chunked", "/close"} {
+		c := newClient()
+		c.Limits.MaxBodyLength = 5
+		err := c.DoTimeout(newRequest(path), &Response{}, 5*time.Second)
+		require.ErrorAs(t, err, &limitErr, path)
+		require.Equal(t, client.LimitBodyLength, limitErr.Kind)
+		require.Equal(t, 5, limitErr.Limit)
+	}
+
+	c = newClient()
+	c.Limits.MaxBodyLength = 10
+	var resp Response
+	require.Nil(t, c.DoTimeout(newRequest("/chunked"), &resp, 5*time.Second))
+	require.Equal(t, "0123456789", readBody(t, &resp))
+}
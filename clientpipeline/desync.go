@@ -0,0 +1,219 @@
+package clientpipeline
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DesyncKind is the kind of a request/response desynchronization anomaly.
+type DesyncKind int
+
+const (
+	// DesyncExtraBytes reports bytes left on the connection after a response
+	// while no other request was outstanding.
+	DesyncExtraBytes DesyncKind = iota + 1
+	// DesyncEarlyResponse reports a response starting before its request was
+	// fully flushed to the connection.
+	DesyncEarlyResponse
+	// DesyncEarlyClose reports the server closing the connection with requests
+	// still unanswered.
+	DesyncEarlyClose
+)
+
+func (k DesyncKind) String() string {
+	switch k {
+	case DesyncExtraBytes:
+		return "extra bytes"
+	case DesyncEarlyResponse:
+		return "early response"
+	case DesyncEarlyClose:
+		return "early close"
+	}
+	return fmt.Sprintf("DesyncKind(%d)", int(k))
+}
+
+// DesyncAnomaly is an anomaly seen on a pipelined connection.
+type DesyncAnomaly struct {
+	Kind   DesyncKind
+	Seq    uint64 // sequence number of the request involved
+	Data   []byte // bytes left after the response, for DesyncExtraBytes
+	Detail string
+	Time   time.Time
+}
+
+// DesyncReport correlates the requests and responses of a pipelined connection.
+type DesyncReport struct {
+	ConnID   uint64
+	Addr     string // remote address of the connection
+	Written  uint64 // requests written
+	Answered uint64 // responses read
+	// CloseAfter is the number of requests the server answered before closing
+	// the connection on its own, 0 if it kept the connection open
+	CloseAfter uint64
+	Closed     bool
+	Anomalies  []DesyncAnomaly
+}
+
+var lastConnID atomic.Uint64
+
+// DefaultMaxDesyncReports is the number of connections whose desync reports
+// are kept when PipelineClient.MaxDesyncReports is not set.
+const DefaultMaxDesyncReports = 1024
+
+// desyncRing keeps the trackers of the last connections opened, the oldest
+// being dropped once max are kept
+type desyncRing struct {
+	mu    sync.Mutex
+	ds    []*connDesync
+	start int // index of the oldest tracker once ds is full
+}
+
+func (r *desyncRing) add(d *connDesync, max int) {
+	if max <= 0 {
+		max = DefaultMaxDesyncReports
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.ds) < max {
+		r.ds = append(r.ds, d)
+		return
+	}
+	r.ds[r.start] = d
+	r.start = (r.start + 1) % len(r.ds)
+}
+
+// snapshot returns the reports of the trackers kept, by connection id and so in
+// the order the connections were opened
+func (r *desyncRing) snapshot() []DesyncReport {
+	r.mu.Lock()
+	ds := append([]*connDesync(nil), r.ds...)
+	r.mu.Unlock()
+	reports := make([]DesyncReport, 0, len(ds))
+	for _, d := range ds {
+		reports = append(reports, d.snapshot())
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].ConnID < reports[j].ConnID })
+	return reports
+}
+
+// connDesync tracks the request/response correlation of a connection. Requests
+// get increasing sequence numbers as they are written, responses are matched to
+// them in order.
+type connDesync struct {
+	id      uint64
+	written atomic.Uint64 // sequence number of the last request written
+	flushed atomic.Int64  // bytes handed to the connection
+
+	mu     sync.Mutex
+	report DesyncReport
+}
+
+func newConnDesync(conn net.Conn) *connDesync {
+	d := &connDesync{id: lastConnID.Add(1)}
+	d.report.ConnID = d.id
+	if addr := conn.RemoteAddr(); addr != nil {
+		d.report.Addr = addr.String()
+	}
+	return d
+}
+
+func (d *connDesync) anomaly(kind DesyncKind, seq uint64, data []byte, detail string) {
+	d.mu.Lock()
+	d.report.Anomalies = append(d.report.Anomalies, DesyncAnomaly{
+		Kind:   kind,
+		Seq:    seq,
+		Data:   data,
+		Detail: detail,
+		Time:   time.Now(),
+	})
+	d.mu.Unlock()
+}
+
+func (d *connDesync) answered(seq uint64) {
+	d.mu.Lock()
+	d.report.Answered = seq
+	d.mu.Unlock()
+}
+
+// readerStopped records the server ending the connection with err, nil for a
+// close-delimited response, while requests were still unanswered.
+func (d *connDesync) readerStopped(err error) {
+	written := d.written.Load()
+	if isTimeoutErr(err) {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if written <= d.report.Answered {
+		return
+	}
+	detail := fmt.Sprintf("%d requests unanswered", written-d.report.Answered)
+	if err != nil {
+		detail += ": " + err.Error()
+	}
+	d.report.Anomalies = append(d.report.Anomalies, DesyncAnomaly{
+		Kind:   DesyncEarlyClose,
+		Seq:    d.report.Answered + 1,
+		Detail: detail,
+		Time:   time.Now(),
+	})
+}
+
+func (d *connDesync) closeRequested(seq uint64) {
+	d.mu.Lock()
+	d.report.CloseAfter = seq
+	d.mu.Unlock()
+}
+
+// closeAfter returns the sequence number of the response the server closed the
+// connection after, 0 if it did not ask to close
+func (d *connDesync) closeAfter() uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.report.CloseAfter
+}
+
+func (d *connDesync) close() {
+	written := d.written.Load()
+	d.mu.Lock()
+	d.report.Written = written
+	d.report.Closed = true
+	d.mu.Unlock()
+}
+
+func (d *connDesync) snapshot() DesyncReport {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r := d.report
+	if !r.Closed {
+		r.Written = d.written.Load()
+	}
+	r.Anomalies = append([]DesyncAnomaly(nil), r.Anomalies...)
+	return r
+}
+
+func isTimeoutErr(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// countingWriter counts the bytes written to the connection so the reader can
+// tell which requests were fully flushed. Bytes are counted as soon as they are
+// handed to the connection, the response may be read before Write returns.
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (cw countingWriter) Write(p []byte) (int, error) {
+	cw.n.Add(int64(len(p)))
+	n, err := cw.w.Write(p)
+	cw.n.Add(int64(n - len(p)))
+	return n, err
+}
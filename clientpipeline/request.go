@@ -64,6 +64,15 @@ func (r *Request) ContentLength() int64 {
 	}
 }
 
+// method returns the request method, taken from the request line for raw requests
+func (r *Request) method() string {
+	if len(r.RawBytes) > 0 {
+		method, _, _ := bytes.Cut(r.RawBytes, []byte(" "))
+		return string(method)
+	}
+	return r.Method
+}
+
 func (r *Request) Write(w *bufio.Writer) error {
 	if r.RawBytes != nil && len(r.RawBytes) > 0 {
 		_, err := w.Write(r.RawBytes)
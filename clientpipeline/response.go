@@ -3,21 +3,32 @@ package clientpipeline
 import (
 	"bufio"
 	"bytes"
-	"errors"
 	"fmt"
 	"io"
-	"net/http/httputil"
 	"strconv"
 	"strings"
+
+	"github.com/secoba/rawhttp/client"
 )
 
 // Response represents an RFC2616 response.
 type Response struct {
 	Version
 	Status
-	Headers []Header
-	body    []byte
-	Body    io.Reader
+	Headers  []Header
+	Trailers []Header // trailer fields of a chunked body
+	body     []byte
+	Body     io.Reader
+	Proxy    string // proxy the connection went through, empty if direct
+	ConnID   uint64 // connection the response was read from, see DesyncReport
+	Seq      uint64 // sequence number of the answered request on its connection
+	Attempts int    // times the request was sent, more than one if it was retried
+
+	Anomalies  []client.Anomaly // deviations from the grammar seen while parsing
+	Chunks     []client.Chunk   // sizes and extensions of the chunks of a chunked body, up to Limits.MaxChunkCount
+	RawChunked []byte           // a chunked body as received, before decoding, up to Limits.MaxRawChunkedLength
+
+	closeDelimited bool // body ended with the connection
 }
 
 // ContentLength returns the length of the body. If the body length is not known
@@ -37,26 +48,43 @@ func (r *Response) ContentLength() int64 {
 
 // CloseRequested returns if Reason includes a Connection: close header.
 func (r *Response) CloseRequested() bool {
+	return r.hasConnectionOption("close")
+}
+
+// closesConn reports whether the server ends the connection after the response:
+// asked with Connection: close, implied by HTTP/1.0 without keep-alive, or
+// because the body is delimited by the close.
+func (r *Response) closesConn() bool {
+	if r.closeDelimited || r.CloseRequested() {
+		return true
+	}
+	return r.Version.Major == 1 && r.Version.Minor == 0 && !r.hasConnectionOption("keep-alive")
+}
+
+// hasConnectionOption reports whether option is listed in the Connection headers
+func (r *Response) hasConnectionOption(option string) bool {
 	for _, h := range r.Headers {
-		if strings.EqualFold(h.Key, "Connection") {
-			return h.Value == "close"
+		if !strings.EqualFold(h.Key, "Connection") {
+			continue
+		}
+		for _, v := range strings.Split(h.Value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), option) {
+				return true
+			}
 		}
 	}
 	return false
 }
 
-// TransferEncoding returns the transfer encoding this message was transmitted with.
-// If not is specified by the sender, "identity" is assumed.
+// TransferEncoding returns the transfer encoding this message was transmitted with,
+// the last coding applied in lower case. If not is specified by the sender,
+// "identity" is assumed.
 func (r *Response) TransferEncoding() string {
-	for _, h := range r.Headers {
-		if strings.EqualFold(h.Key, "Transfer-Encoding") {
-			switch h.Value {
-			case "identity", "chunked":
-				return h.Value
-			}
-		}
+	codings := client.TransferCodings(toClientHeaders(r.Headers))
+	if len(codings) == 0 {
+		return "identity"
 	}
-	return "identity"
+	return codings[len(codings)-1]
 }
 
 // Status represents an HTTP status code.
@@ -65,39 +93,152 @@ type Status struct {
 	Reason string
 }
 
+// Read reads a whole response from r, including its body.
 func (resp *Response) Read(r *bufio.Reader) error {
-	version, code, msg, err := resp.ReadStatusLine(r)
-	var headers []Header
-	if err != nil {
-		return fmt.Errorf("ReadStatusLine: %v", err)
-	}
+	return resp.read(r, "", client.ParseOptions{})
+}
+
+// read reads a whole response answering a request made with method, parsed
+// according to options. Interim 1xx responses are skipped, the body is framed
+// and read completely so the next response starts right after it. An HTTP/0.9
+// response is read until the connection closes.
+func (resp *Response) read(r *bufio.Reader, method string, options client.ParseOptions) error {
+	resp.Anomalies = nil
 	for {
-		var key, value string
-		var done bool
-		key, value, done, err = resp.ReadHeader(r)
-		if err != nil || done {
-			break
+		http09, anomalies, err := client.DetectHTTP09(r, options)
+		resp.Anomalies = append(resp.Anomalies, anomalies...)
+		if err != nil {
+			return err
+		}
+		if http09 {
+			resp.Version = Version{Major: client.HTTP_0_9.Major, Minor: client.HTTP_0_9.Minor}
+			resp.Status = Status{Code: client.SUCCESS_OK, Reason: "OK"}
+			resp.Headers, resp.Trailers, resp.Chunks, resp.RawChunked = nil, nil, nil, nil
+			resp.closeDelimited = true
+			resp.body, err = resp.readAll(r, options.MaxBodyLength)
+			resp.Body = bytes.NewReader(resp.body)
+			return err
+		}
+
+		version, code, msg, anomalies, err := client.ReadStatusLine(r, options)
+		resp.Anomalies = append(resp.Anomalies, anomalies...)
+		if err != nil {
+			return fmt.Errorf("ReadStatusLine: %w", err)
+		}
+		headers, err := resp.readHeaders(r, options)
+		if err != nil {
+			return err
 		}
-		if key == "" {
-			// empty header values are valid, rfc 2616 s4.2.
-			err = errors.New("invalid header") //nolint
-			break
+		anomalies, err = client.FramingAnomalies(toClientHeaders(headers), options.Mode)
+		resp.Anomalies = append(resp.Anomalies, anomalies...)
+		if err != nil {
+			return err
 		}
-		headers = append(headers, Header{key, value})
+
+		resp.Version = Version{Major: version.Major, Minor: version.Minor}
+		resp.Status = Status{Code: code, Reason: msg}
+		resp.Headers = headers
+		if code >= 100 && code < 200 && code != 101 {
+			continue
+		}
+		return resp.readBody(r, method, options)
+	}
+}
+
+// readHeaders reads a header section, recording its anomalies in resp.
+func (resp *Response) readHeaders(r *bufio.Reader, options client.ParseOptions) ([]Header, error) {
+	headers, anomalies, err := client.ReadHeaders(r, options)
+	resp.Anomalies = append(resp.Anomalies, anomalies...)
+	return fromClientHeaders(headers), err
+}
+
+func fromClientHeaders(headers []client.Header) []Header {
+	out := make([]Header, 0, len(headers))
+	for _, h := range headers {
+		out = append(out, Header{h.Key, h.Value})
+	}
+	return out
+}
+
+func toClientHeaders(headers []Header) []client.Header {
+	out := make([]client.Header, 0, len(headers))
+	for _, h := range headers {
+		out = append(out, client.Header{Key: h.Key, Value: h.Value})
+	}
+	return out
+}
+
+// hasBody reports whether a response to method may carry a body, rfc 9112 s6.3.
+func (resp *Response) hasBody(method string) bool {
+	code := resp.Status.Code
+	switch {
+	case strings.EqualFold(method, "HEAD"):
+		return false
+	case code >= 100 && code < 200, code == 204, code == 304:
+		return false
+	}
+	return true
+}
+
+// readBody reads the body framed by the response headers into an owned buffer.
+func (resp *Response) readBody(r *bufio.Reader, method string, options client.ParseOptions) error {
+	var err error
+	resp.body = nil
+	resp.Trailers = nil
+	resp.Chunks = nil
+	resp.RawChunked = nil
+	resp.closeDelimited = false
+
+	switch {
+	case !resp.hasBody(method):
+	case resp.TransferEncoding() == "chunked":
+		cr := client.NewChunkedReader(r, options)
+		resp.body, err = resp.readAll(cr, options.MaxBodyLength)
+		resp.Chunks, resp.RawChunked = cr.Chunks(), cr.Raw()
+		resp.Trailers = fromClientHeaders(cr.Trailers())
+		resp.Anomalies = append(resp.Anomalies, cr.Anomalies()...)
+	case resp.TransferEncoding() != "identity":
+		// chunked is not the final coding, the body runs until the close, rfc 9112 s6.3
+		resp.closeDelimited = true
+		resp.body, err = resp.readAll(r, options.MaxBodyLength)
+	case resp.ContentLength() >= 0:
+		resp.body, err = resp.readLength(r, resp.ContentLength(), options.MaxBodyLength)
+	default:
+		// no framing, the body is delimited by the connection close
+		resp.closeDelimited = true
+		resp.body, err = resp.readAll(r, options.MaxBodyLength)
 	}
+	resp.Body = bytes.NewReader(resp.body)
+	return err
+}
 
-	resp.Version = version
-	resp.Status = Status{Code: code, Reason: msg}
-	resp.Headers = headers
-	resp.Body = resp.ReadBody(r)
+// readLength reads a body of length bytes. The buffer grows as the body
+// arrives, whatever length the server announced.
+func (resp *Response) readLength(r io.Reader, length int64, limit int) ([]byte, error) {
+	if limit > 0 && length > int64(limit) {
+		return nil, resp.bodyLimitError(limit)
+	}
+	body, err := io.ReadAll(io.LimitReader(r, length))
+	if err == nil && int64(len(body)) < length {
+		err = io.ErrUnexpectedEOF
+	}
+	return body, err
+}
 
-	if l := resp.ContentLength(); l >= 0 {
-		resp.Body = io.LimitReader(resp.Body, l)
-	} else if resp.TransferEncoding() == "chunked" {
-		resp.Body = httputil.NewChunkedReader(resp.Body)
+// readAll reads r to the end, failing past limit bytes if set.
+func (resp *Response) readAll(r io.Reader, limit int) ([]byte, error) {
+	if limit <= 0 {
+		return io.ReadAll(r)
 	}
+	body, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if len(body) > limit {
+		return body[:limit], resp.bodyLimitError(limit)
+	}
+	return body, err
+}
 
-	return nil
+func (resp *Response) bodyLimitError(limit int) error {
+	return &client.LimitError{Kind: client.LimitBodyLength, Limit: limit, Headers: toClientHeaders(resp.Headers)}
 }
 
 func (resp *Response) ReadVersion(r *bufio.Reader) (Version, error) {
@@ -190,16 +331,8 @@ func (resp *Response) ReadStatusCode(r *bufio.Reader) (int, error) {
 
 // ReadStatusLine reads the status line.
 func (resp *Response) ReadStatusLine(r *bufio.Reader) (Version, int, string, error) {
-	version, err := resp.ReadVersion(r)
-	if err != nil {
-		return Version{}, 0, "", err
-	}
-	code, err := resp.ReadStatusCode(r)
-	if err != nil {
-		return Version{}, 0, "", err
-	}
-	msg, _, err := r.ReadLine()
-	return version, code, string(msg), err
+	version, code, msg, _, err := client.ReadStatusLine(r, client.ParseOptions{})
+	return Version{Major: version.Major, Minor: version.Minor}, code, msg, err
 }
 
 // ReadHeader reads a http header.
@@ -211,26 +344,18 @@ func (resp *Response) ReadHeader(r *bufio.Reader) (string, string, bool, error)
 	if line := string(line); line == "\r\n" || line == "\n" {
 		return "", "", true, nil
 	}
-	v := bytes.SplitN(line, []byte(":"), 2)
-	if len(v) != 2 {
-		return "", "", false, fmt.Errorf("invalid header line: %q", line)
-	}
-	return string(bytes.TrimSpace(v[0])), string(bytes.TrimSpace(v[1])), false, nil
+	key, value, _, err := client.ParseHeaderLine(line, client.Lenient)
+	return key, value, false, err
 }
 
+// ReadBody reads the body framed by the headers already read into resp and
+// returns it as an owned reader.
 func (resp *Response) ReadBody(r *bufio.Reader) io.Reader {
-	l := resp.ContentLength()
-	if l > 0 {
-		resp.body = make([]byte, l)
-		io.ReadFull(r, resp.body) //nolint
-
-		return bytes.NewReader(resp.body)
-	}
-
-	return r
+	_ = resp.readBody(r, "", client.ParseOptions{})
+	return resp.Body
 }
 
 // readLine returns a []byte terminated by a \r\n.
 func (resp *Response) readLine(r *bufio.Reader) ([]byte, error) {
-	return r.ReadBytes('\n')
+	return client.ReadLine(r, client.DefaultMaxHeaderLineLength)
 }
@@ -233,7 +233,7 @@ func (d *TCPDialer) getTCPAddrs(ctx context.Context, addr string, dualStack bool
 	return e.addrs, idx, nil
 }
 
-func resolveTCPAddrs(pCtx context.Context, addr string, dualStack bool, resolver Resolver) ([]net.TCPAddr, error) {
+func resolveTCPAddrs(ctx context.Context, addr string, dualStack bool, resolver Resolver) ([]net.TCPAddr, error) {
 	host, portS, err := net.SplitHostPort(addr)
 	if err != nil {
 		return nil, err
@@ -247,7 +247,6 @@ func resolveTCPAddrs(pCtx context.Context, addr string, dualStack bool, resolver
 		resolver = net.DefaultResolver
 	}
 
-	ctx, _ := context.WithCancel(pCtx)
 	ipaddrs, err := resolver.LookupIPAddr(ctx, host)
 	if err != nil {
 		return nil, err
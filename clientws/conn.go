@@ -0,0 +1,170 @@
+package clientws
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// acceptGUID is appended to the key to compute Sec-WebSocket-Accept, rfc 6455 s1.3
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Version is the Sec-WebSocket-Version sent in handshakes.
+const Version = "13"
+
+// NewKey returns a random Sec-WebSocket-Key.
+func NewKey() string {
+	var key [16]byte
+	_, _ = rand.Read(key[:])
+	return base64.StdEncoding.EncodeToString(key[:])
+}
+
+// Accept returns the Sec-WebSocket-Accept answering key.
+func Accept(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// CloseError is returned by ReadMessage when the server sends a close frame.
+type CloseError struct {
+	Code   uint16 // 1005 (no status) if the frame had no payload
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket closed: %d %s", e.Code, e.Reason)
+}
+
+// ErrInterleavedMessage is returned by ReadMessage for a data frame starting a
+// message while a fragmented one is still open, rfc 6455 s5.4.
+var ErrInterleavedMessage = errors.New("data frame inside a fragmented message")
+
+// ErrUnexpectedContinuation is returned by ReadMessage for a continuation frame
+// without a fragmented message open, rfc 6455 s5.4.
+var ErrUnexpectedContinuation = errors.New("continuation frame without a fragmented message")
+
+// Conn is a WebSocket client connection after the handshake. Frames are read
+// and written with ReadFrame and WriteFrame, messages with ReadMessage and
+// WriteMessage. ReadMessage answers pings on its own, ReadFrame leaves every
+// frame, control frames included, to the caller.
+type Conn struct {
+	net.Conn
+	MaxPayloadLength uint64 // DefaultMaxPayloadLength if not set
+
+	r  io.Reader
+	mu sync.Mutex // serializes writes
+}
+
+// NewConn returns a Conn writing to c and reading from r, which holds the bytes
+// already buffered after the handshake response, or from c if r is nil.
+func NewConn(c net.Conn, r io.Reader) *Conn {
+	if r == nil {
+		r = c
+	}
+	return &Conn{Conn: c, r: bufio.NewReader(r)}
+}
+
+// WriteFrame writes f as is.
+func (c *Conn) WriteFrame(f *Frame) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.Conn.Write(f.Bytes())
+	return err
+}
+
+// ReadFrame reads the next frame, its payload unmasked.
+func (c *Conn) ReadFrame() (*Frame, error) {
+	maxPayloadLength := c.MaxPayloadLength
+	if maxPayloadLength == 0 {
+		maxPayloadLength = DefaultMaxPayloadLength
+	}
+	return readFrame(c.r, maxPayloadLength)
+}
+
+// WriteMessage writes data in a single masked frame of opcode op.
+func (c *Conn) WriteMessage(op Opcode, data []byte) error {
+	return c.WriteFrame(NewFrame(op, true, data))
+}
+
+// WriteFragments writes a message of opcode op fragmented in one frame per
+// fragment, rfc 6455 s5.4.
+func (c *Conn) WriteFragments(op Opcode, fragments ...[]byte) error {
+	for i, fragment := range fragments {
+		if i > 0 {
+			op = OpContinuation
+		}
+		if err := c.WriteFrame(NewFrame(op, i == len(fragments)-1, fragment)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteClose writes a close frame with code and reason.
+func (c *Conn) WriteClose(code uint16, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, code)
+	return c.WriteMessage(OpClose, append(payload, reason...))
+}
+
+// ReadMessage reads a message, joining its fragments. Pings are answered with
+// pongs automatically and pongs dropped, a close frame is returned as a
+// *CloseError. Fragments not following rfc 6455 s5.4 fail with
+// ErrInterleavedMessage or ErrUnexpectedContinuation, use ReadFrame to read
+// them as they are.
+func (c *Conn) ReadMessage() (Opcode, []byte, error) {
+	var (
+		op         Opcode
+		data       []byte
+		fragmented bool // whether a message was started
+	)
+	for {
+		f, err := c.ReadFrame()
+		if err != nil {
+			return op, data, err
+		}
+		switch f.Opcode {
+		case OpPing:
+			if err := c.WriteMessage(OpPong, f.Payload); err != nil {
+				return op, data, err
+			}
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			closeErr := &CloseError{Code: 1005}
+			if len(f.Payload) >= 2 {
+				closeErr.Code = binary.BigEndian.Uint16(f.Payload)
+				closeErr.Reason = string(f.Payload[2:])
+			}
+			return op, data, closeErr
+		case OpContinuation:
+			if !fragmented {
+				return op, data, ErrUnexpectedContinuation
+			}
+		default:
+			if fragmented {
+				return op, data, ErrInterleavedMessage
+			}
+			op = f.Opcode
+			fragmented = true
+		}
+		data = append(data, f.Payload...)
+		if f.Fin {
+			return op, data, nil
+		}
+	}
+}
+
+// NewFrame returns a frame masked with a random key, as clients send them.
+func NewFrame(op Opcode, fin bool, payload []byte) *Frame {
+	f := &Frame{Fin: fin, Opcode: op, Masked: true, Payload: payload}
+	_, _ = rand.Read(f.MaskKey[:])
+	return f
+}
@@ -0,0 +1,161 @@
+package clientws
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Opcode is the opcode of a frame, any 4 bits value can be written.
+type Opcode byte
+
+const (
+	OpContinuation Opcode = 0x0
+	OpText         Opcode = 0x1
+	OpBinary       Opcode = 0x2
+	OpClose        Opcode = 0x8
+	OpPing         Opcode = 0x9
+	OpPong         Opcode = 0xa
+)
+
+// IsControl reports whether op is a control opcode, rfc 6455 s5.5.
+func (op Opcode) IsControl() bool {
+	return op&0x8 != 0
+}
+
+// DefaultMaxPayloadLength bounds the payload of the frames read.
+const DefaultMaxPayloadLength = 16 << 20
+
+// ErrPayloadTooLong is returned when reading a frame longer than allowed.
+var ErrPayloadTooLong = errors.New("frame payload too long")
+
+// Frame is a WebSocket frame, rfc 6455 s5.2. Fields are written as is, invalid
+// frames included.
+type Frame struct {
+	Fin     bool
+	RSV     byte   // the three reserved bits, RSV1 being 0x4
+	Opcode  Opcode // written on 4 bits
+	Masked  bool
+	MaskKey [4]byte
+	Payload []byte // unmasked payload, masked with MaskKey when written if Masked
+
+	// Length is the payload length written instead of len(Payload) if set
+	Length *uint64
+	// LengthBytes forces the encoding of the length on 2 or 8 extended bytes,
+	// the shortest one being used if 0
+	LengthBytes int
+}
+
+// Header returns the bytes of the frame header.
+func (f *Frame) Header() []byte {
+	length := uint64(len(f.Payload))
+	if f.Length != nil {
+		length = *f.Length
+	}
+	lengthBytes := f.LengthBytes
+	if lengthBytes == 0 {
+		switch {
+		case length > 0xffff:
+			lengthBytes = 8
+		case length > 125:
+			lengthBytes = 2
+		}
+	}
+
+	b0 := byte(f.Opcode)&0xf | (f.RSV&0x7)<<4
+	if f.Fin {
+		b0 |= 0x80
+	}
+	var b1 byte
+	if f.Masked {
+		b1 = 0x80
+	}
+	header := []byte{b0, b1}
+	switch lengthBytes {
+	case 2:
+		header[1] |= 126
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	case 8:
+		header[1] |= 127
+		header = binary.BigEndian.AppendUint64(header, length)
+	default:
+		header[1] |= byte(length) & 0x7f
+	}
+	if f.Masked {
+		header = append(header, f.MaskKey[:]...)
+	}
+	return header
+}
+
+// Bytes returns the frame as written on the wire.
+func (f *Frame) Bytes() []byte {
+	b := f.Header()
+	payload := append([]byte(nil), f.Payload...)
+	if f.Masked {
+		mask(payload, f.MaskKey)
+	}
+	return append(b, payload...)
+}
+
+func (f *Frame) String() string {
+	return fmt.Sprintf("fin=%v rsv=%d opcode=%#x masked=%v length=%d", f.Fin, f.RSV, byte(f.Opcode), f.Masked, len(f.Payload))
+}
+
+// readFrame reads a frame from r, unmasking its payload.
+func readFrame(r io.Reader, maxPayloadLength uint64) (*Frame, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	f := &Frame{
+		Fin:    head[0]&0x80 != 0,
+		RSV:    head[0] >> 4 & 0x7,
+		Opcode: Opcode(head[0] & 0xf),
+		Masked: head[1]&0x80 != 0,
+	}
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		length, f.LengthBytes = uint64(binary.BigEndian.Uint16(ext[:])), 2
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		length, f.LengthBytes = binary.BigEndian.Uint64(ext[:]), 8
+	}
+	if length > maxPayloadLength {
+		return f, ErrPayloadTooLong
+	}
+	if f.Masked {
+		if _, err := io.ReadFull(r, f.MaskKey[:]); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+	}
+	f.Payload = make([]byte, length)
+	if _, err := io.ReadFull(r, f.Payload); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if f.Masked {
+		mask(f.Payload, f.MaskKey)
+	}
+	return f, nil
+}
+
+func mask(b []byte, key [4]byte) {
+	for i := range b {
+		b[i] ^= key[i%4]
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
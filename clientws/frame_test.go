@@ -0,0 +1,62 @@
+package clientws
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrameBytes(t *testing.T) {
+	f := &Frame{Fin: true, Opcode: OpText, Payload: []byte("hi")}
+	require.Equal(t, []byte{0x81, 0x02, 'h', 'i'}, f.Bytes())
+
+	// masked, custom opcode and reserved bits, length on 8 bytes
+	f = &Frame{Opcode: 0x3, RSV: 0x4, Masked: true, MaskKey: [4]byte{1, 2, 3, 4}, Payload: []byte("abcde"), LengthBytes: 8}
+	b := f.Bytes()
+	require.Equal(t, []byte{0x43, 0x80 | 127, 0, 0, 0, 0, 0, 0, 0, 5, 1, 2, 3, 4}, b[:14])
+	read, err := readFrame(bytes.NewReader(b), DefaultMaxPayloadLength)
+	require.Nil(t, err)
+	require.Equal(t, f.Payload, read.Payload)
+	require.Equal(t, Opcode(0x3), read.Opcode)
+	require.Equal(t, byte(0x4), read.RSV)
+	require.False(t, read.Fin)
+
+	// declared length differing from the payload
+	length := uint64(300)
+	f = &Frame{Fin: true, Opcode: OpBinary, Payload: []byte("x"), Length: &length}
+	require.Equal(t, []byte{0x82, 126, 0x01, 0x2c, 'x'}, f.Bytes())
+
+	_, err = readFrame(bytes.NewReader(f.Bytes()), 100)
+	require.Equal(t, ErrPayloadTooLong, err)
+}
+
+func TestAccept(t *testing.T) {
+	// rfc 6455 s1.3 example
+	require.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", Accept("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestReadMessage(t *testing.T) {
+	var wire []byte
+	wire = append(wire, (&Frame{Opcode: OpText, Payload: []byte("frag")}).Bytes()...)
+	wire = append(wire, (&Frame{Fin: true, Opcode: OpPong}).Bytes()...)
+	wire = append(wire, (&Frame{Fin: true, Opcode: OpContinuation, Payload: []byte("mented")}).Bytes()...)
+	wire = append(wire, (&Frame{Fin: true, Opcode: OpClose, Payload: []byte{0x03, 0xe8, 'b', 'y', 'e'}}).Bytes()...)
+
+	c := NewConn(nil, bytes.NewReader(wire))
+	op, data, err := c.ReadMessage()
+	require.Nil(t, err)
+	require.Equal(t, OpText, op)
+	require.Equal(t, "fragmented", string(data))
+
+	_, _, err = c.ReadMessage()
+	require.Equal(t, &CloseError{Code: 1000, Reason: "bye"}, err)
+
+	// a message starting inside another one, or a continuation of none
+	wire = append((&Frame{Opcode: OpText, Payload: []byte("a")}).Bytes(), (&Frame{Fin: true, Opcode: OpBinary, Payload: []byte("b")}).Bytes()...)
+	_, _, err = NewConn(nil, bytes.NewReader(wire)).ReadMessage()
+	require.Equal(t, ErrInterleavedMessage, err)
+	wire = (&Frame{Fin: true, Opcode: OpContinuation, Payload: []byte("c")}).Bytes()
+	_, _, err = NewConn(nil, bytes.NewReader(wire)).ReadMessage()
+	require.Equal(t, ErrUnexpectedContinuation, err)
+}
@@ -3,6 +3,7 @@ package pkg
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -23,6 +24,11 @@ type Dialer interface {
 	DialTimeout(protocol, addr string, timeout time.Duration, options *Options) (Conn, error) // Dial dials a remote http server with timeout returning a Conn.
 }
 
+// ProxyChainDialer is implemented by dialers able to tunnel through a chain of proxies.
+type ProxyChainDialer interface {
+	DialWithProxyChain(protocol, addr string, proxyURLs []string, timeout time.Duration, options *Options) (Conn, error)
+}
+
 type dialer struct {
 	sync.Mutex // protects following fields
 	//conns      map[string][]Conn // maps addr to a, possibly empty, slice of existing Conns
@@ -52,40 +58,83 @@ func (d *dialer) dialTimeout(protocol, addr string, timeout time.Duration, optio
 	d.Unlock()
 	c, err := clientDial(protocol, addr, timeout, options)
 	return &conn{
-		Client: client.NewClient(c),
+		Client: client.NewClientWithOptions(c, parseOptions(options)),
 		Conn:   c,
 		dialer: d,
 	}, err
 }
 
 func (d *dialer) DialWithProxy(protocol, addr, proxyURL string, timeout time.Duration, options *Options) (Conn, error) {
-	var c net.Conn
+	dial, err := proxyDialer(proxyURL, options.ForwardProxy && protocol == "http", timeout, options.FastDialer)
+	if err != nil {
+		return nil, err
+	}
+	c, err := dial(addr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errProxy, err)
+	}
+	return d.tunnelConn(c, protocol, addr, proxyURL, timeout, options)
+}
+
+// errProxy marks errors caused by the proxy rather than the target
+var errProxy = errors.New("proxy error")
+
+var errNoProxyChain = errors.New("dialer does not support proxy chains")
+
+// proxyDialer returns the proxy package dialer matching the scheme of proxyURL,
+// forward selects plain-HTTP forwarding instead of CONNECT for http proxies
+func proxyDialer(proxyURL string, forward bool, timeout time.Duration, fd *fastdialer.Dialer) (proxy.DialFunc, error) {
 	u, err := url.Parse(proxyURL)
 	if err != nil {
 		return nil, fmt.Errorf("unsupported proxy error: %w", err)
 	}
 	switch u.Scheme {
 	case "http":
-		c, err = proxy.HTTPFastDialer(proxyURL, timeout, options.FastDialer)(addr)
+		if forward {
+			return proxy.HTTPForwardFastDialer(proxyURL, timeout, fd), nil
+		}
+		return proxy.HTTPFastDialer(proxyURL, timeout, fd), nil
+	case "https":
+		return proxy.HTTPSFastDialer(proxyURL, timeout, fd), nil
+	case "socks4", "socks4a":
+		return proxy.Socks4FastDialer(proxyURL, timeout, fd), nil
 	case "socks5", "socks5h":
-		c, err = proxy.Socks5Dialer(proxyURL, timeout)(addr)
+		return proxy.Socks5Dialer(proxyURL, timeout), nil
 	default:
 		return nil, fmt.Errorf("unsupported proxy protocol: %s", proxyURL)
 	}
+}
+
+func (d *dialer) DialWithProxyChain(protocol, addr string, proxyURLs []string, timeout time.Duration, options *Options) (Conn, error) {
+	c, err := proxy.ChainFastDialer(proxyURLs, timeout, options.FastDialer)(addr)
 	if err != nil {
-		return nil, fmt.Errorf("proxy error: %w", err)
+		return nil, fmt.Errorf("proxy chain error: %w", err)
+	}
+	return d.tunnelConn(c, protocol, addr, strings.Join(proxyURLs, ","), timeout, options)
+}
+
+// tunnelConn wraps a connection established through a proxy, sending the PROXY
+// protocol header and performing the tls handshake with the target if required
+func (d *dialer) tunnelConn(c net.Conn, protocol, addr, proxyURL string, timeout time.Duration, options *Options) (Conn, error) {
+	if err := writeProxyProtocol(c, timeout, options); err != nil {
+		c.Close()
+		return nil, err
 	}
 	if protocol == "https" {
-		if c, err = TlsHandshake(c, addr, timeout); err != nil {
+		tlsConn, err := TlsHandshake(c, addr, timeout)
+		if err != nil {
+			c.Close()
 			return nil, fmt.Errorf("tls handshake error: %w", err)
 		}
+		c = tlsConn
 	}
 
 	return &conn{
-		Client: client.NewClient(c),
+		Client: client.NewClientWithOptions(c, parseOptions(options)),
 		Conn:   c,
 		dialer: d,
-	}, err
+		proxy:  proxyURL,
+	}, nil
 }
 
 func clientDial(protocol, addr string, timeout time.Duration, options *Options) (net.Conn, error) {
@@ -96,6 +145,10 @@ func clientDial(protocol, addr string, timeout time.Duration, options *Options)
 	//	ctx = pCtx
 	//}
 
+	if options.ProxyProtocol != nil || options.Network != "" || options.Address != "" {
+		return layeredDial(protocol, addr, timeout, options)
+	}
+
 	// http
 	if protocol == "http" {
 		if options.FastDialer != nil {
@@ -135,34 +188,98 @@ func clientDial(protocol, addr string, timeout time.Duration, options *Options)
 	return options.FastDialer.DialTLS(context.Background(), "tcp", addr)
 }
 
+// layeredDial connects to addr, or to the target set by options.Network and
+// options.Address, and writes the PROXY protocol header before any tls
+// handshake, so the combined tls dial of fastdialer can't be used
+func layeredDial(protocol, addr string, timeout time.Duration, options *Options) (net.Conn, error) {
+	var (
+		c   net.Conn
+		err error
+	)
+	network, address := dialTarget(options.Network, options.Address, addr)
+	if options.FastDialer != nil && network == "tcp" {
+		c, err = options.FastDialer.Dial(context.Background(), network, address)
+	} else if timeout > 0 {
+		c, err = net.DialTimeout(network, address, timeout)
+	} else {
+		c, err = net.Dial(network, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = writeProxyProtocol(c, timeout, options); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if protocol != "https" {
+		return c, nil
+	}
+
+	serverName := options.SNI
+	if serverName == "" {
+		serverName = hostname(addr)
+	}
+	tlsConn, err := tlsHandshake(c, &tls.Config{
+		InsecureSkipVerify: true,
+		Renegotiation:      tls.RenegotiateOnceAsClient,
+		ServerName:         serverName,
+	}, timeout)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// writeProxyProtocol sends the PROXY protocol header configured in options, if any
+func writeProxyProtocol(c net.Conn, timeout time.Duration, options *Options) error {
+	if options.ProxyProtocol == nil {
+		return nil
+	}
+	if timeout > 0 {
+		_ = c.SetWriteDeadline(time.Now().Add(timeout))
+		defer c.SetWriteDeadline(time.Time{}) //nolint
+	}
+	if err := proxy.WriteProxyProtocol(c, options.ProxyProtocol); err != nil {
+		return fmt.Errorf("proxy protocol error: %w", err)
+	}
+	return nil
+}
+
 // TlsHandshake tls handshake on a plain connection
 func TlsHandshake(conn net.Conn, addr string, timeout time.Duration) (net.Conn, error) {
+	return tlsHandshake(conn, &tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         hostname(addr),
+	}, timeout)
+}
+
+func tlsHandshake(conn net.Conn, config *tls.Config, timeout time.Duration) (net.Conn, error) {
 	var (
 		ctx    = context.Background()
 		cancel context.CancelFunc
 	)
-
-	colonPos := strings.LastIndex(addr, ":")
-	if colonPos == -1 {
-		colonPos = len(addr)
-	}
-	hostname := addr[:colonPos]
-
 	if timeout > 0 {
 		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 
-	tlsConn := tls.Client(conn, &tls.Config{
-		InsecureSkipVerify: true,
-		ServerName:         hostname,
-	})
+	tlsConn := tls.Client(conn, config)
 	if err := tlsConn.HandshakeContext(ctx); err != nil {
 		return nil, err
 	}
 	return tlsConn, nil
 }
 
+// hostname strips the port from addr
+func hostname(addr string) string {
+	colonPos := strings.LastIndex(addr, ":")
+	if colonPos == -1 {
+		colonPos = len(addr)
+	}
+	return addr[:colonPos]
+}
+
 // Conn is an interface implemented by a connection
 type Conn interface {
 	client.Client
@@ -173,10 +290,37 @@ type Conn interface {
 	Stop() error
 }
 
+// ProxiedConn is implemented by connections reporting the proxy they were
+// established through.
+type ProxiedConn interface {
+	Proxy() string // empty if direct
+}
+
 type conn struct {
 	client.Client
 	net.Conn
 	*dialer
+	proxy string
+}
+
+// netConn returns the network connection under c, nil if c was not dialed by rawhttp
+func netConn(c Conn) net.Conn {
+	if c, ok := c.(*conn); ok {
+		return c.Conn
+	}
+	return nil
+}
+
+// connProxy returns the proxy c was established through, empty if direct or unknown
+func connProxy(c Conn) string {
+	if pc, ok := c.(ProxiedConn); ok {
+		return pc.Proxy()
+	}
+	return ""
+}
+
+func (c *conn) Proxy() string {
+	return c.proxy
 }
 
 func (c *conn) Release() {
@@ -195,3 +339,8 @@ func (c *conn) SetTimeout(timeout time.Duration) {
 	//_ = c.SetReadDeadline(time.Now().Add(timeout))
 	//_ = c.SetWriteDeadline(time.Now().Add(timeout))
 }
+
+// parseOptions returns how responses read through a connection are parsed
+func parseOptions(options *Options) client.ParseOptions {
+	return client.ParseOptions{Mode: options.ParseMode, HTTP09Fallback: options.HTTP09Fallback, Limits: options.Limits}
+}
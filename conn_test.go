@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/secoba/rawhttp/proxy"
+	"github.com/stretchr/testify/require"
+)
+
+// proxyProtocolServer answers http requests preceded by a PROXY v1 header and
+// reports the received headers on the returned channel
+func proxyProtocolServer(t *testing.T) (string, chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { l.Close() })
+	headers := make(chan string, 10)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				br := bufio.NewReader(c)
+				line, err := br.ReadString('\n')
+				if err != nil {
+					return
+				}
+				headers <- line
+				for {
+					req, err := http.ReadRequest(br)
+					if err != nil {
+						return
+					}
+					_, _ = io.Copy(io.Discard, req.Body)
+					_, _ = c.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+				}
+			}()
+		}
+	}()
+	return l.Addr().String(), headers
+}
+
+func TestProxyProtocol(t *testing.T) {
+	addr, headers := proxyProtocolServer(t)
+	header := &proxy.ProxyProtocolHeader{
+		Version:     1,
+		Source:      &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 4242},
+		Destination: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 80},
+	}
+	expected := "PROXY TCP4 203.0.113.7 10.0.0.1 4242 80\r\n"
+
+	t.Run("client", func(t *testing.T) {
+		options := *DefaultOptions
+		options.Timeout = 5 * time.Second
+		options.ProxyProtocol = header
+		client := NewClient(&options)
+		conn, err := client.CreateConnection("http://"+addr+"/", &options)
+		require.Nil(t, err)
+		defer conn.Close()
+		_, resp, err := client.Get(conn, "http://"+addr+"/")
+		require.Nil(t, err)
+		require.Equal(t, 200, resp.StatusCode)
+		require.Equal(t, expected, <-headers)
+	})
+
+	t.Run("pipeline", func(t *testing.T) {
+		options := DefaultPipelineOptions
+		options.Host = addr
+		options.ProxyProtocol = header
+		client := NewPipelineClient(context.Background(), options)
+		_, resp, err := client.Get("http://" + addr + "/")
+		require.Nil(t, err)
+		require.Equal(t, 200, resp.StatusCode)
+		require.Equal(t, expected, <-headers)
+	})
+}
@@ -0,0 +1,147 @@
+package pkg
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	urlutil "github.com/projectdiscovery/utils/url"
+	"github.com/secoba/rawhttp/clienth2"
+	"golang.org/x/net/http2"
+)
+
+// DialHTTP2 opens an HTTP/2 connection with frame-level control to the host of
+// url: h2 negotiated with ALPN for https and prior-knowledge h2c for http. The
+// connection goes through the proxies, network and address overrides and PROXY
+// protocol header of the client options.
+func (c *Client) DialHTTP2(ctx context.Context, url string) (*clienth2.Conn, error) {
+	dialOptions := c.Options
+	if rewritten, socket := unixURL(url); socket != "" {
+		unixOptions := *c.Options
+		unixOptions.Network, unixOptions.Address = "unix", socket
+		url, dialOptions = rewritten, &unixOptions
+	}
+	u, err := urlutil.ParseURL(url, true)
+	if err != nil {
+		return nil, err
+	}
+	isTLS := strings.EqualFold(u.Scheme, "https")
+	host := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if isTLS {
+			port = "443"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	var options clienth2.Options
+	if isTLS {
+		serverName := c.Options.SNI
+		if serverName == "" {
+			serverName = u.Hostname()
+		}
+		options.TLSConfig = &tls.Config{InsecureSkipVerify: true, ServerName: serverName}
+	}
+	options.Dial = http2Dial(dialOptions)
+	if c.Options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Options.Timeout)
+		defer cancel()
+	}
+	conn, err := clienth2.Dial(ctx, host, options)
+	if err != nil {
+		return nil, err
+	}
+	if c.Options.Timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(c.Options.Timeout))
+	}
+	return conn, nil
+}
+
+// http2Dial returns the dialer of HTTP/2 connections, the one of pipelined
+// connections for proxies, overrides and the PROXY protocol, nil to dial directly
+func http2Dial(options *Options) clienth2.DialFunc {
+	dial := pipelineDial(PipelineOptions{
+		Timeout:          options.Timeout,
+		Proxy:            options.Proxy,
+		ProxyDialTimeout: options.ProxyDialTimeout,
+		ProxyChain:       options.ProxyChain,
+		ProxyPool:        options.ProxyPool,
+		ProxyProtocol:    options.ProxyProtocol,
+		Network:          options.Network,
+		Address:          options.Address,
+	})
+	if dial == nil {
+		if options.FastDialer != nil {
+			return options.FastDialer.Dial
+		}
+		return nil
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dial(ctx, addr)
+	}
+}
+
+// H2CUpgrade is the outcome of an h2c upgrade attempt
+type H2CUpgrade struct {
+	Accepted bool               // whether the server switched to HTTP/2 with a 101
+	Response *http.Response     // the HTTP/1.1 response to the upgrade request
+	Conn     *clienth2.Conn     // the upgraded connection more streams are sent on, nil if not accepted
+	Stream   *clienth2.Response // response to the upgrade request, read over HTTP/2 from stream 1
+}
+
+// h2cSettings are the settings sent in HTTP2-Settings and after the upgrade
+var h2cSettings = []http2.Setting{{ID: http2.SettingEnablePush, Val: 0}}
+
+// DoH2CUpgrade sends a request on conn asking to switch to h2c, adding the
+// Connection, Upgrade and HTTP2-Settings headers unless headers has them. When
+// the server accepts, the connection carries on as HTTP/2 in Conn, to be closed
+// by the caller, and the response to the request is read from stream 1.
+// Otherwise Response is the regular response, its body closing conn.
+func (c *Client) DoH2CUpgrade(conn Conn, method, url, uripath string, headers map[string][]string, body io.Reader) (*H2CUpgrade, error) {
+	resp, stream, err := c.DoTunnel(conn, method, url, uripath, h2cUpgradeHeaders(headers), body)
+	result := &H2CUpgrade{Response: resp}
+	if errors.Is(err, ErrTunnelRefused) {
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		// a tunnel opened by CONNECT is no upgrade
+		stream.Close()
+		return result, nil
+	}
+	result.Accepted = true
+	if result.Conn, err = clienth2.NewUpgradedConn(stream, clienth2.Options{Settings: h2cSettings}); err != nil {
+		return result, err
+	}
+	result.Stream, err = result.Conn.ReadResponse(1)
+	return result, err
+}
+
+// h2cUpgradeHeaders returns a copy of headers with the headers of an h2c
+// upgrade request added when missing
+func h2cUpgradeHeaders(headers map[string][]string) map[string][]string {
+	upgrade := make(map[string][]string, len(headers)+3)
+	for k, v := range headers {
+		upgrade[k] = v
+	}
+	defaults := map[string]string{
+		"Connection":     "Upgrade, HTTP2-Settings",
+		"Upgrade":        clienth2.UpgradeProto,
+		"HTTP2-Settings": clienth2.UpgradeSettings(h2cSettings),
+	}
+	for k, v := range defaults {
+		if !hasHeader(upgrade, k) {
+			upgrade[k] = []string{v}
+		}
+	}
+	return upgrade
+}
@@ -0,0 +1,100 @@
+package pkg
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/secoba/rawhttp/clienth2"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestDialHTTP2(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Proto+" "+r.URL.Path)
+	})
+	h2cServer := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	defer h2cServer.Close()
+	tlsServer := httptest.NewUnstartedServer(handler)
+	require.Nil(t, http2.ConfigureServer(tlsServer.Config, &http2.Server{}))
+	tlsServer.TLS = tlsServer.Config.TLSConfig
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+
+	proxyURL := connectProxy(t)
+	for _, ts := range []*httptest.Server{h2cServer, tlsServer} {
+		scheme, host, _ := strings.Cut(ts.URL, "://")
+		dials := map[string]func(options *Options) string{
+			"direct": func(options *Options) string { return ts.URL },
+			"proxy": func(options *Options) string {
+				options.Proxy = proxyURL
+				return ts.URL
+			},
+			"address": func(options *Options) string {
+				options.Address = host
+				return scheme + "://daemon.local"
+			},
+		}
+		for name, setup := range dials {
+			t.Run(scheme+" "+name, func(t *testing.T) {
+				options := *DefaultOptions
+				options.Timeout = 5 * time.Second
+				url := setup(&options)
+				conn, err := NewClient(&options).DialHTTP2(context.Background(), url)
+				require.Nil(t, err)
+				defer conn.Close()
+				resp, err := conn.Do(clienth2.NewRequest("GET", "https", "localhost", "/h2", nil, nil))
+				require.Nil(t, err)
+				require.Equal(t, 200, resp.Status)
+				require.Equal(t, "HTTP/2.0 /h2", string(resp.Body))
+			})
+		}
+	}
+}
+
+func TestDoH2CUpgrade(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Proto+" "+r.URL.Path)
+	})
+	h2cServer := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	defer h2cServer.Close()
+	h1Server := httptest.NewServer(handler)
+	defer h1Server.Close()
+
+	options := *DefaultOptions
+	options.Timeout = 5 * time.Second
+	c := NewClient(&options)
+
+	conn, err := c.CreateConnection(h2cServer.URL, &options)
+	require.Nil(t, err)
+	upgrade, err := c.DoH2CUpgrade(conn, "GET", h2cServer.URL+"/first", "", nil, nil)
+	require.Nil(t, err)
+	defer upgrade.Conn.Close()
+	require.True(t, upgrade.Accepted)
+	require.Equal(t, 101, upgrade.Response.StatusCode)
+	require.Equal(t, uint32(1), upgrade.Stream.StreamID)
+	require.Equal(t, "HTTP/1.1 /first", string(upgrade.Stream.Body))
+
+	// more streams on the upgraded connection
+	resp, err := upgrade.Conn.Do(clienth2.NewRequest("GET", "http", "localhost", "/second", nil, nil))
+	require.Nil(t, err)
+	require.Equal(t, uint32(3), resp.StreamID)
+	require.Equal(t, "HTTP/2.0 /second", string(resp.Body))
+
+	conn, err = c.CreateConnection(h1Server.URL, &options)
+	require.Nil(t, err)
+	upgrade, err = c.DoH2CUpgrade(conn, "GET", h1Server.URL+"/refused", "", nil, nil)
+	require.Nil(t, err)
+	require.False(t, upgrade.Accepted)
+	require.Nil(t, upgrade.Conn)
+	body, err := io.ReadAll(upgrade.Response.Body)
+	require.Nil(t, err)
+	require.Equal(t, "HTTP/1.1 /refused", string(body))
+	upgrade.Response.Body.Close()
+}
@@ -0,0 +1,201 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/secoba/rawhttp/client"
+)
+
+// SyncRequest is a request sent with last-byte synchronization
+type SyncRequest struct {
+	Method  string
+	URL     string
+	URIPath string
+	Headers map[string][]string
+	Body    io.Reader
+	Raw     []byte
+}
+
+// SyncResult is the outcome of a request sent with last-byte synchronization
+type SyncResult struct {
+	Request  *client.Request
+	Response *http.Response
+	Err      error
+	Released time.Time     // when the held back bytes were written
+	Received time.Time     // when the response headers were read
+	Elapsed  time.Duration // from release to response headers
+}
+
+var errNoSyncRequests = errors.New("no requests to synchronize")
+
+// DoLastByteSync sends every request on its own connection, holding back the
+// final bytes of each until all the others are written, then releases them
+// together so the requests complete on the server at the same moment. Response
+// bodies must be closed by the caller, closing the connection too.
+func (c *Client) DoLastByteSync(reqs []SyncRequest) []SyncResult {
+	results := make([]SyncResult, len(reqs))
+	conns := make([]Conn, len(reqs))
+	heads := make([][]byte, len(reqs))
+	tails := make([][]byte, len(reqs))
+
+	// connect and write everything but the final bytes
+	var ready sync.WaitGroup
+	for i := range reqs {
+		ready.Add(1)
+		go func(i int) {
+			defer ready.Done()
+			result := &results[i]
+			var raw []byte
+			result.Request, raw, result.Err = c.serializeSyncRequest(reqs[i])
+			if result.Err != nil {
+				return
+			}
+			heads[i], tails[i] = splitSyncBytes(raw, c.Options.LastByteSyncSize)
+			if conns[i], result.Err = c.CreateConnection(reqs[i].URL, c.Options); result.Err != nil {
+				return
+			}
+			result.Err = writeRaw(conns[i], heads[i])
+		}(i)
+	}
+	ready.Wait()
+
+	// release the final bytes together
+	release := make(chan struct{})
+	var done sync.WaitGroup
+	for i := range reqs {
+		if results[i].Err != nil {
+			if conns[i] != nil {
+				conns[i].Close()
+			}
+			continue
+		}
+		done.Add(1)
+		go func(i int) {
+			defer done.Done()
+			<-release
+			c.releaseSyncRequest(conns[i], reqs[i], tails[i], &results[i])
+			if results[i].Err != nil {
+				conns[i].Close()
+			}
+		}(i)
+	}
+	close(release)
+	done.Wait()
+	return results
+}
+
+// DoLastByteSyncPipelined sends the requests pipelined on a single connection
+// to the host of the first one, holding back the final bytes of the last
+// request until all the others are written. Responses are read in order and
+// their bodies fully buffered, the connection is closed when done.
+func (c *Client) DoLastByteSyncPipelined(reqs []SyncRequest) ([]SyncResult, error) {
+	if len(reqs) == 0 {
+		return nil, errNoSyncRequests
+	}
+	results := make([]SyncResult, len(reqs))
+	var buf bytes.Buffer
+	for i := range reqs {
+		var raw []byte
+		var err error
+		if results[i].Request, raw, err = c.serializeSyncRequest(reqs[i]); err != nil {
+			return nil, err
+		}
+		buf.Write(raw)
+	}
+	head, tail := splitSyncBytes(buf.Bytes(), c.Options.LastByteSyncSize)
+
+	conn, err := c.CreateConnection(reqs[0].URL, c.Options)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := writeRaw(conn, head); err != nil {
+		return nil, err
+	}
+
+	released := time.Now()
+	if err := writeRaw(conn, tail); err != nil {
+		return nil, err
+	}
+	for i := range results {
+		result := &results[i]
+		result.Released = released
+		result.Response, result.Err = c.readSyncResponse(conn, reqs[i], true)
+		result.Received = time.Now()
+		result.Elapsed = result.Received.Sub(released)
+		if result.Err != nil {
+			// the responses can't be told apart anymore
+			for j := i + 1; j < len(results); j++ {
+				results[j].Err = result.Err
+			}
+			break
+		}
+	}
+	return results, nil
+}
+
+// serializeSyncRequest builds the request and returns it along with its wire bytes
+func (c *Client) serializeSyncRequest(r SyncRequest) (*client.Request, []byte, error) {
+	req, _, _, err := buildRequest(r.Method, r.URL, r.URIPath, r.Headers, r.Body, r.Raw, c.Options)
+	if err != nil {
+		return nil, nil, err
+	}
+	var buf bytes.Buffer
+	if err := client.NewClient(&buf).WriteRequest(req); err != nil {
+		return req, nil, err
+	}
+	return req, buf.Bytes(), nil
+}
+
+// releaseSyncRequest writes the held back bytes and reads the response
+func (c *Client) releaseSyncRequest(conn Conn, r SyncRequest, tail []byte, result *SyncResult) {
+	result.Released = time.Now()
+	if result.Err = writeRaw(conn, tail); result.Err != nil {
+		return
+	}
+	result.Response, result.Err = c.readSyncResponse(conn, r, false)
+	result.Received = time.Now()
+	result.Elapsed = result.Received.Sub(result.Released)
+}
+
+// readSyncResponse reads a response from conn, buffering its body if the
+// connection is still needed for the next one
+func (c *Client) readSyncResponse(conn Conn, r SyncRequest, buffer bool) (*http.Response, error) {
+	resp, err := conn.ReadResponse(c.Options.ForceReadAllBody)
+	if err != nil {
+		return nil, err
+	}
+	if buffer {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = bytes.NewReader(body)
+	}
+	return toHTTPResponse(resp, conn, &responseMeta{proxy: connProxy(conn)}, newHTTPRequest(r.Method, r.URL, r.Headers))
+}
+
+// writeRaw writes raw as is to conn
+func writeRaw(conn Conn, raw []byte) error {
+	if len(raw) == 0 {
+		// an empty raw request would be written as a regular one
+		return nil
+	}
+	return conn.WriteRequest(&client.Request{RawBytes: raw})
+}
+
+// splitSyncBytes splits raw before its last n bytes, 1 if n isn't positive
+func splitSyncBytes(raw []byte, n int) ([]byte, []byte) {
+	if n <= 0 {
+		n = 1
+	}
+	if n > len(raw) {
+		n = len(raw)
+	}
+	return raw[:len(raw)-n], raw[len(raw)-n:]
+}
@@ -0,0 +1,104 @@
+package pkg
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// arrivalServer answers every request with its path and records when each
+// request was completely received
+func arrivalServer(t *testing.T) (string, func() []time.Time) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { l.Close() })
+	var (
+		mu       sync.Mutex
+		arrivals []time.Time
+	)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				br := bufio.NewReader(c)
+				for {
+					req, err := http.ReadRequest(br)
+					if err != nil {
+						return
+					}
+					_, _ = io.Copy(io.Discard, req.Body)
+					mu.Lock()
+					arrivals = append(arrivals, time.Now())
+					mu.Unlock()
+					body := req.URL.Path
+					_, _ = c.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body))
+				}
+			}()
+		}
+	}()
+	return l.Addr().String(), func() []time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]time.Time(nil), arrivals...)
+	}
+}
+
+func TestDoLastByteSync(t *testing.T) {
+	addr, arrivals := arrivalServer(t)
+	options := *DefaultOptions
+	options.Timeout = 5 * time.Second
+	options.LastByteSyncSize = 2
+	c := NewClient(&options)
+
+	var reqs []SyncRequest
+	for _, path := range []string{"/a", "/b", "/c", "/d", "/e"} {
+		reqs = append(reqs, SyncRequest{Method: "GET", URL: "http://" + addr + path})
+	}
+
+	start := time.Now()
+	results := c.DoLastByteSync(reqs)
+	require.Len(t, results, len(reqs))
+	for i, result := range results {
+		require.Nil(t, result.Err)
+		body, err := io.ReadAll(result.Response.Body)
+		require.Nil(t, err)
+		require.Equal(t, reqs[i].URL[len("http://"+addr):], string(body))
+		require.False(t, result.Released.Before(start))
+		require.True(t, result.Elapsed >= 0)
+		result.Response.Body.Close()
+	}
+
+	// no request completed before the final bytes were released
+	released := results[0].Released
+	for _, result := range results {
+		if result.Released.Before(released) {
+			released = result.Released
+		}
+	}
+	times := arrivals()
+	require.Len(t, times, len(reqs))
+	for _, at := range times {
+		require.False(t, at.Before(released))
+	}
+
+	results, err := c.DoLastByteSyncPipelined(reqs[:3])
+	require.Nil(t, err)
+	for i, result := range results {
+		require.Nil(t, result.Err)
+		body, err := io.ReadAll(result.Response.Body)
+		require.Nil(t, err)
+		require.Equal(t, reqs[i].URL[len("http://"+addr):], string(body))
+	}
+	require.Len(t, arrivals(), len(reqs)+3)
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/projectdiscovery/fastdialer/fastdialer"
 	"github.com/secoba/rawhttp/client"
+	"github.com/secoba/rawhttp/proxy"
 )
 
 // Options contains configuration options for rawhttp client
@@ -19,8 +20,19 @@ type Options struct {
 	CustomRawBytes         []byte
 	Proxy                  string
 	ProxyDialTimeout       time.Duration
+	ProxyChain             []string   // ordered list of proxies tunneled hop by hop, takes precedence over Proxy
+	ProxyPool              *ProxyPool // rotates connections across proxies, takes precedence over Proxy and ProxyChain
+	ForwardProxy           bool       // sends absolute-form requests to http proxies instead of CONNECT for http targets
+	ForwardProxySkipRaw    bool       // leaves raw request bytes untouched in forward proxy mode
 	SNI                    string
 	FastDialer             *fastdialer.Dialer
+	ProxyProtocol          *proxy.ProxyProtocolHeader // PROXY protocol header written right after connecting, before tls
+	LastByteSyncSize       int                        // bytes held back per request by last-byte sync, 1 if not set
+	ParseMode              client.ParseMode           // rejects malformed responses when client.Strict, see ResponseAnomalies
+	HTTP09Fallback         bool                       // reads non-HTTP responses as HTTP/0.9 instead of failing with a *client.NonHTTPError
+	Limits                 client.Limits              // bounds the size of response heads, exceeding one fails with a *client.LimitError
+	Network                string                     // network dialed instead of tcp, such as unix, proxies being skipped when set
+	Address                string                     // address dialed instead of the url host, such as a socket path, proxies being skipped when set
 }
 
 // DefaultOptions is the default configuration options for the client
@@ -2,36 +2,265 @@ package pkg
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	retryablehttp "github.com/projectdiscovery/retryablehttp-go"
 	urlutil "github.com/projectdiscovery/utils/url"
+	"github.com/secoba/rawhttp/client"
 	"github.com/secoba/rawhttp/clientpipeline"
+	"github.com/secoba/rawhttp/proxy"
 )
 
 // PipelineClient is a client for making pipelined http requests
 type PipelineClient struct {
-	client  *clientpipeline.PipelineClient
+	ctx     context.Context
 	options PipelineOptions
+	dial    clientpipeline.DialFunc
+
+	mu      sync.Mutex
+	client  *clientpipeline.PipelineClient // single target when options.Host is set
+	origins map[string]*origin             // clients by origin otherwise
+	closed  bool
+}
+
+// origin is the pipelined client of an origin
+type origin struct {
+	client   *clientpipeline.PipelineClient
+	lastUsed time.Time // when the last call ended
+	calls    int       // calls in progress
 }
 
-// NewPipelineClient creates a new pipelined http request client
+// originIdleTimeout is how long an origin without calls in progress is kept,
+// its connections being closed by then
+var originIdleTimeout = clientpipeline.DefaultMaxIdleConnDuration
+
+// ErrMixedOrigins is returned by DoBatch for requests to different origins.
+var ErrMixedOrigins = errors.New("batched requests target different origins")
+
+// NewPipelineClient creates a new pipelined http request client. Requests go
+// to options.Host if set, otherwise to the origin of their url, with up to
+// options.MaxConnections connections for each origin.
 func NewPipelineClient(ctx context.Context, options PipelineOptions) *PipelineClient {
 	client := &PipelineClient{
-		client: &clientpipeline.PipelineClient{
-			Ctx:                ctx,
-			Dial:               options.Dialer,
-			Addr:               options.Host,
-			MaxConns:           options.MaxConnections,
-			MaxPendingRequests: options.MaxPendingRequests,
-			ReadTimeout:        options.Timeout,
-		},
+		ctx:     ctx,
 		options: options,
+		dial:    pipelineDial(options),
+		origins: make(map[string]*origin),
+	}
+	if options.Host != "" {
+		client.client = client.newOriginClient(options.Host, options.IsTLS, client.dial)
+	}
+	return client
+}
+
+// newOriginClient creates the pipelined client sending requests to addr
+func (c *PipelineClient) newOriginClient(addr string, isTLS bool, dial clientpipeline.DialFunc) *clientpipeline.PipelineClient {
+	client := &clientpipeline.PipelineClient{
+		Ctx:                c.ctx,
+		Dial:               dial,
+		Addr:               addr,
+		MaxConns:           c.options.MaxConnections,
+		MaxPendingRequests: c.options.MaxPendingRequests,
+		ReadTimeout:        c.options.Timeout,
+		IsTLS:              isTLS,
+		ParseMode:          c.options.ParseMode,
+		HTTP09Fallback:     c.options.HTTP09Fallback,
+		Limits:             c.options.Limits,
+
+		MaxIdemponentCallAttempts: c.options.MaxIdemponentCallAttempts,
+		RetryIf:                   c.options.RetryIf,
+		MaxDesyncReports:          c.options.MaxDesyncReports,
+	}
+	if isTLS {
+		client.TLSConfig = &tls.Config{InsecureSkipVerify: true, ServerName: c.options.SNI}
 	}
 	return client
 }
 
+// originClient returns the pipelined client for the origin of u, or for the
+// unix socket if set, along with the func to call once the call using it ended
+func (c *PipelineClient) originClient(u *urlutil.URL, socket string) (*clientpipeline.PipelineClient, func(), error) {
+	if c.client != nil {
+		return c.client, func() {}, nil
+	}
+	key, addr, isTLS := c.origin(u, socket)
+	dial := c.dial
+	if socket != "" {
+		unixOptions := c.options
+		unixOptions.Dialer, unixOptions.Network, unixOptions.Address = nil, "unix", socket
+		dial = pipelineDial(unixOptions)
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, nil, clientpipeline.ErrPipelineClientClosed
+	}
+	var evicted []*clientpipeline.PipelineClient
+	o, ok := c.origins[key]
+	if !ok {
+		evicted = c.evictIdleOrigins(time.Now())
+		o = &origin{client: c.newOriginClient(addr, isTLS, dial)}
+		c.origins[key] = o
+	}
+	o.calls++
+	c.mu.Unlock()
+
+	for _, client := range evicted {
+		client.Close()
+	}
+	return o.client, func() {
+		c.mu.Lock()
+		o.calls--
+		o.lastUsed = time.Now()
+		c.mu.Unlock()
+	}, nil
+}
+
+// origin returns the origin of u, or of the unix socket if set, along with the
+// address and whether tls is used
+func (c *PipelineClient) origin(u *urlutil.URL, socket string) (string, string, bool) {
+	scheme := strings.ToLower(u.Scheme)
+	isTLS := c.options.IsTLS || scheme == "https"
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if isTLS {
+			port = "443"
+		}
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+	if socket != "" {
+		return scheme + unixSuffix + "://" + socket, addr, isTLS
+	}
+	return scheme + "://" + addr, addr, isTLS
+}
+
+// evictIdleOrigins drops the origins without calls in progress unused for
+// originIdleTimeout, returning their clients to be closed.
+func (c *PipelineClient) evictIdleOrigins(now time.Time) []*clientpipeline.PipelineClient {
+	var evicted []*clientpipeline.PipelineClient
+	for key, o := range c.origins {
+		if o.calls == 0 && now.Sub(o.lastUsed) > originIdleTimeout {
+			delete(c.origins, key)
+			evicted = append(evicted, o.client)
+		}
+	}
+	return evicted
+}
+
+// clients returns the pipelined clients created so far
+func (c *PipelineClient) clients() []*clientpipeline.PipelineClient {
+	if c.client != nil {
+		return []*clientpipeline.PipelineClient{c.client}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clients := make([]*clientpipeline.PipelineClient, 0, len(c.origins))
+	for _, o := range c.origins {
+		clients = append(clients, o.client)
+	}
+	return clients
+}
+
+// pipelineDial returns the dialer for pipelined connections, going through the
+// configured proxies and sending the PROXY protocol header if any. A nil dialer
+// makes clientpipeline dial directly.
+func pipelineDial(options PipelineOptions) clientpipeline.DialFunc {
+	dial := pipelineProxyDial(options)
+	if options.ProxyProtocol == nil {
+		return dial
+	}
+	if dial == nil {
+		dial = clientpipeline.Dial
+	}
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		c, err := dial(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+		if err := proxy.WriteProxyProtocol(c, options.ProxyProtocol); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("proxy protocol error: %w", err)
+		}
+		return c, nil
+	}
+}
+
+func pipelineProxyDial(options PipelineOptions) clientpipeline.DialFunc {
+	switch {
+	case options.Dialer != nil:
+		return options.Dialer
+	case options.Network != "" || options.Address != "":
+		// overridden targets are dialed directly
+		return targetDial(options.Network, options.Address, options.Timeout)
+	case options.ProxyPool != nil:
+		return proxyPoolDial(options.ProxyPool, options.ProxyDialTimeout)
+	case len(options.ProxyChain) > 0:
+		return proxiedDial(proxy.ChainDialer(options.ProxyChain, options.ProxyDialTimeout), strings.Join(options.ProxyChain, ","))
+	case options.Proxy != "":
+		dial, err := proxyDialer(options.Proxy, false, options.ProxyDialTimeout, nil)
+		if err != nil {
+			return func(ctx context.Context, addr string) (net.Conn, error) {
+				return nil, err
+			}
+		}
+		return proxiedDial(dial, options.Proxy)
+	}
+	return nil
+}
+
+// proxiedDial adapts a proxy dialer to clientpipeline, recording the proxy on the connection
+func proxiedDial(dial proxy.DialFunc, proxyURL string) clientpipeline.DialFunc {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		c, err := dialContext(ctx, dial, addr)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: %w", errProxy, err)
+		}
+		return &proxiedConn{Conn: c, proxy: proxyURL}, nil
+	}
+}
+
+// dialContext runs dial until ctx is done, closing the connection if it is
+// established afterwards
+func dialContext(ctx context.Context, dial proxy.DialFunc, addr string) (net.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		c, err := dial(addr)
+		done <- result{c, err}
+	}()
+	select {
+	case r := <-done:
+		return r.conn, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
 // Head makes a HEAD request to a given URL
 func (c *PipelineClient) Head(url string) (*clientpipeline.Request, *http.Response, error) {
 	return c.DoRaw("HEAD", url, "", nil, nil, nil)
@@ -65,23 +294,119 @@ func (c *PipelineClient) Dor(req *retryablehttp.Request) (*clientpipeline.Reques
 	url := req.URL.String()
 	body := req.Body
 
-	return c.do(method, url, "", headers, body, nil, c.options)
+	return c.do(context.Background(), time.Time{}, method, url, "", headers, body, nil, c.options)
+}
+
+// DoTimeout sends a http request and waits at most timeout for the response
+func (c *PipelineClient) DoTimeout(req *http.Request, timeout time.Duration) (*clientpipeline.Request, *http.Response, error) {
+	return c.DoDeadline(req, time.Now().Add(timeout))
+}
+
+// DoDeadline sends a http request and waits for the response until deadline
+func (c *PipelineClient) DoDeadline(req *http.Request, deadline time.Time) (*clientpipeline.Request, *http.Response, error) {
+	return c.do(context.Background(), deadline, req.Method, req.URL.String(), "", req.Header, req.Body, nil, c.options)
+}
+
+// DoContext sends a http request and waits for the response until ctx is done
+func (c *PipelineClient) DoContext(ctx context.Context, req *http.Request) (*clientpipeline.Request, *http.Response, error) {
+	return c.do(ctx, time.Time{}, req.Method, req.URL.String(), "", req.Header, req.Body, nil, c.options)
+}
+
+// DoRawContext does a raw request waiting for the response until ctx is done
+func (c *PipelineClient) DoRawContext(ctx context.Context, method, url, uripath string, headers map[string][]string, body io.Reader, raw []byte) (*clientpipeline.Request, *http.Response, error) {
+	return c.do(ctx, time.Time{}, method, url, uripath, headers, body, raw, c.options)
 }
 
 // DoRaw does a raw request with some configuration
 func (c *PipelineClient) DoRaw(method, url, uripath string, headers map[string][]string, body io.Reader, raw []byte) (*clientpipeline.Request, *http.Response, error) {
-	return c.do(method, url, uripath, headers, body, raw, c.options)
+	return c.do(context.Background(), time.Time{}, method, url, uripath, headers, body, raw, c.options)
 }
 
 // DoRawWithOptions performs a raw request with additional options
 func (c *PipelineClient) DoRawWithOptions(method, url, uripath string, headers map[string][]string, body io.Reader, raw []byte, options PipelineOptions) (*clientpipeline.Request, *http.Response, error) {
-	return c.do(method, url, uripath, headers, body, raw, options)
+	return c.do(context.Background(), time.Time{}, method, url, uripath, headers, body, raw, options)
+}
+
+// do performs the request, waiting for the response until ctx is done (if it can
+// be canceled) or until deadline passes (if not zero)
+func (c *PipelineClient) do(ctx context.Context, deadline time.Time, method, url, uripath string, headers map[string][]string, body io.Reader, raw []byte, options PipelineOptions) (*clientpipeline.Request, *http.Response, error) {
+	req, u, err := toPipelineRequest(method, url, uripath, headers, body, raw, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, socket := unixURL(url)
+	client, release, err := c.originClient(u, socket)
+	if err != nil {
+		return req, nil, err
+	}
+	defer release()
+	var resp clientpipeline.Response
+
+	switch {
+	case ctx.Done() != nil:
+		err = client.DoContext(ctx, req, &resp)
+	case !deadline.IsZero():
+		err = client.DoDeadline(req, &resp, deadline)
+	default:
+		err = client.Do(req, &resp)
+	}
+
+	if err != nil {
+		return req, nil, err
+	}
+	r, err := toPipelineHTTPResponse(&resp, newHTTPRequest(method, url, headers))
+	return req, r, err
 }
 
-func (c *PipelineClient) do(method, url, uripath string, headers map[string][]string, body io.Reader, raw []byte, options PipelineOptions) (*clientpipeline.Request, *http.Response, error) {
+// DoBatch sends the requests pipelined in a single write on a dedicated
+// connection to their origin and returns the responses read, in order, before
+// any error. Requests to different origins fail with ErrMixedOrigins, unless
+// options.Host routes them all.
+func (c *PipelineClient) DoBatch(reqs []*http.Request) ([]*clientpipeline.Request, []*http.Response, error) {
+	var (
+		pipelineReqs []*clientpipeline.Request
+		client       *clientpipeline.PipelineClient
+		batchOrigin  string
+	)
+	for _, req := range reqs {
+		pipelineReq, u, err := toPipelineRequest(req.Method, req.URL.String(), "", req.Header, req.Body, nil, c.options)
+		if err != nil {
+			return nil, nil, err
+		}
+		_, socket := unixURL(req.URL.String())
+		if key, _, _ := c.origin(u, socket); client == nil {
+			batchOrigin = key
+			var release func()
+			if client, release, err = c.originClient(u, socket); err != nil {
+				return nil, nil, err
+			}
+			defer release()
+		} else if c.client == nil && key != batchOrigin {
+			return nil, nil, fmt.Errorf("%w: %s and %s", ErrMixedOrigins, batchOrigin, key)
+		}
+		pipelineReqs = append(pipelineReqs, pipelineReq)
+	}
+	if client == nil {
+		return nil, nil, nil
+	}
+	resps, err := client.DoBatch(pipelineReqs)
+	var httpResps []*http.Response
+	for i, resp := range resps {
+		r, convErr := toPipelineHTTPResponse(resp, reqs[i])
+		if convErr != nil {
+			return pipelineReqs, httpResps, convErr
+		}
+		httpResps = append(httpResps, r)
+	}
+	return pipelineReqs, httpResps, err
+}
+
+// toPipelineRequest builds the pipelined request for url, returned parsed
+func toPipelineRequest(method, url, uripath string, headers map[string][]string, body io.Reader, raw []byte, options PipelineOptions) (*clientpipeline.Request, *urlutil.URL, error) {
 	if headers == nil {
 		headers = make(map[string][]string)
 	}
+	url, _ = unixURL(url)
 	u, err := urlutil.ParseURL(url, true)
 	if err != nil {
 		return nil, nil, err
@@ -98,26 +423,86 @@ func (c *PipelineClient) do(method, url, uripath string, headers map[string][]st
 	if uripath != "" {
 		path = uripath
 	}
+	// name the origin routed to, unless the caller set another host
+	if options.AutomaticHostHeader && len(raw) == 0 && !hasHeader(headers, "Host") {
+		withHost := map[string][]string{"Host": {u.Host}}
+		for k, v := range headers {
+			withHost[k] = v
+		}
+		headers = withHost
+	}
 
-	req := clientpipeline.ToRequest(
+	return clientpipeline.ToRequest(
 		method, u.Host, path, nil, headers, body,
-		raw, options.AutomaticHostHeader, options.AutomaticContentLength)
-	var resp clientpipeline.Response
+		raw, options.AutomaticHostHeader, options.AutomaticContentLength), u, nil
+}
 
-	err = c.client.Do(req, &resp)
+// toPipelineHTTPResponse converts a pipelined response to a net/http response
+// answering req
+func toPipelineHTTPResponse(resp *clientpipeline.Response, req *http.Request) (*http.Response, error) {
+	raw := &client.Response{
+		Version: client.Version{Major: resp.Version.Major, Minor: resp.Version.Minor},
+		Status:  client.Status{Code: resp.Status.Code, Reason: resp.Status.Reason},
+		Headers: toClientHeaders(resp.Headers),
+		Body:    resp.Body,
 
-	// response => net/http response
-	r := http.Response{
-		StatusCode:    resp.Status.Code,
-		ContentLength: resp.ContentLength(),
-		Header:        make(http.Header),
+		Anomalies: resp.Anomalies,
 	}
+	r, err := toHTTPResponse(raw, io.NopCloser(nil), &responseMeta{proxy: resp.Proxy, attempts: resp.Attempts}, req)
+	if err != nil {
+		return nil, err
+	}
+	// the pipelined client has always canonicalized header keys
+	r.Header = canonicalHeaders(raw.Headers)
+	if len(resp.Trailers) > 0 {
+		r.Trailer = canonicalHeaders(toClientHeaders(resp.Trailers))
+	}
+	return r, nil
+}
 
-	for _, header := range resp.Headers {
-		r.Header.Set(header.Key, header.Value)
+func toClientHeaders(h []clientpipeline.Header) []client.Header {
+	var r []client.Header
+	for _, hh := range h {
+		r = append(r, client.Header{Key: hh.Key, Value: hh.Value})
 	}
+	return r
+}
 
-	r.Body = io.NopCloser(resp.Body)
+// DesyncReports returns the request/response correlation reports of the last
+// pipelined connections opened to the origins in use, in the order they were
+// opened
+func (c *PipelineClient) DesyncReports() []clientpipeline.DesyncReport {
+	var reports []clientpipeline.DesyncReport
+	for _, client := range c.clients() {
+		reports = append(reports, client.DesyncReports()...)
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].ConnID < reports[j].ConnID })
+	return reports
+}
 
-	return req, &r, err
+// Close stops the client, failing the requests not answered yet and closing
+// its connections
+func (c *PipelineClient) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	for _, client := range c.clients() {
+		client.Close()
+	}
+	return nil
+}
+
+// Shutdown stops accepting requests and waits for the ones in progress until
+// ctx is done, then closes the client
+func (c *PipelineClient) Shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	var err error
+	for _, client := range c.clients() {
+		if shutdownErr := client.Shutdown(ctx); shutdownErr != nil {
+			err = shutdownErr
+		}
+	}
+	return err
 }
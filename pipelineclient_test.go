@@ -0,0 +1,246 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/secoba/rawhttp/clientpipeline"
+	"github.com/stretchr/testify/require"
+)
+
+// connectProxy starts a stand-in http CONNECT proxy
+func connectProxy(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				req, err := http.ReadRequest(bufio.NewReader(c))
+				if err != nil || req.Method != http.MethodConnect {
+					return
+				}
+				upstream, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					return
+				}
+				defer upstream.Close()
+				_, _ = c.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+				go func() {
+					_, _ = io.Copy(upstream, c)
+				}()
+				_, _ = io.Copy(c, upstream)
+			}()
+		}
+	}()
+	return "http://" + l.Addr().String()
+}
+
+func TestPipelineClientProxy(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello " + r.URL.Path))
+	})
+	servers := map[string]*httptest.Server{
+		"http":  httptest.NewServer(handler),
+		"https": httptest.NewTLSServer(handler),
+	}
+	proxyURL := connectProxy(t)
+
+	for name, ts := range servers {
+		defer ts.Close()
+		t.Run(name, func(t *testing.T) {
+			host := strings.TrimPrefix(ts.URL, name+"://")
+			options := DefaultPipelineOptions
+			options.Host = host
+			options.IsTLS = name == "https"
+			options.Proxy = proxyURL
+			client := NewPipelineClient(context.Background(), options)
+
+			headers := map[string][]string{"Host": {host}}
+			_, resp, err := client.DoRaw("GET", ts.URL+"/path", "", headers, nil, nil)
+			require.Nil(t, err)
+			require.Equal(t, 200, resp.StatusCode)
+			body, err := io.ReadAll(resp.Body)
+			require.Nil(t, err)
+			require.Equal(t, "hello /path", string(body))
+			require.Equal(t, proxyURL, ResponseProxy(resp))
+		})
+	}
+}
+
+func TestPipelineClientOrigins(t *testing.T) {
+	var conns sync.Map
+	newServer := func(tls bool) *httptest.Server {
+		ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(r.Host + r.URL.Path))
+		}))
+		ts.Config.ConnState = func(c net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				n, _ := conns.LoadOrStore(c.LocalAddr().String(), new(atomic.Int32))
+				n.(*atomic.Int32).Add(1)
+			}
+		}
+		if tls {
+			ts.StartTLS()
+		} else {
+			ts.Start()
+		}
+		t.Cleanup(ts.Close)
+		return ts
+	}
+	servers := []*httptest.Server{newServer(false), newServer(false), newServer(true)}
+
+	options := DefaultPipelineOptions
+	options.MaxConnections = 1
+	client := NewPipelineClient(context.Background(), options)
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		for _, ts := range servers {
+			wg.Add(1)
+			go func(ts *httptest.Server) {
+				defer wg.Done()
+				_, resp, err := client.Get(ts.URL + "/path")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				body, err := io.ReadAll(resp.Body)
+				if want := strings.TrimPrefix(strings.TrimPrefix(ts.URL, "http://"), "https://") + "/path"; err != nil || string(body) != want {
+					t.Errorf("got %q %v, want %q", body, err, want)
+				}
+			}(ts)
+		}
+	}
+	wg.Wait()
+
+	// one connection for each origin
+	for _, ts := range servers {
+		n, ok := conns.Load(ts.Listener.Addr().String())
+		require.True(t, ok)
+		require.Equal(t, int32(1), n.(*atomic.Int32).Load())
+	}
+	require.Len(t, client.DesyncReports(), len(servers))
+
+	// a batch goes to a single origin
+	reqs := make([]*http.Request, 0, len(servers))
+	for _, ts := range servers {
+		req, err := http.NewRequest("GET", ts.URL+"/batch", nil)
+		require.Nil(t, err)
+		reqs = append(reqs, req)
+	}
+	_, _, err := client.DoBatch(reqs)
+	require.ErrorIs(t, err, ErrMixedOrigins)
+	_, resps, err := client.DoBatch(reqs[:1])
+	require.Nil(t, err)
+	require.Len(t, resps, 1)
+
+	// idle origins are dropped when new ones are added
+	timeout := originIdleTimeout
+	originIdleTimeout = 0
+	defer func() { originIdleTimeout = timeout }()
+	time.Sleep(time.Millisecond)
+	_, _, err = client.Get(servers[1].URL + "/last")
+	require.Nil(t, err)
+	evicted := client.clients()
+	require.Len(t, evicted, len(servers))
+	_, _, err = client.Get(strings.Replace(servers[0].URL, "127.0.0.1", "localhost", 1) + "/new")
+	require.Nil(t, err)
+	client.mu.Lock()
+	require.Len(t, client.origins, 1)
+	client.mu.Unlock()
+	// and closed, their goroutines joined
+	for _, c := range evicted {
+		require.Equal(t, clientpipeline.ErrPipelineClientClosed, c.Do(&clientpipeline.Request{}, nil))
+	}
+}
+
+func TestPipelineClientHTTPResponse(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, _ = zw.Write([]byte("compressed body"))
+	require.Nil(t, zw.Close())
+	raw := "HTTP/1.1 201 Made\r\nset-cookie: a=1\r\nSet-Cookie: b=2\r\nContent-Encoding: gzip\r\n" +
+		"Content-Length: " + strconv.Itoa(gz.Len()) + "\r\n\r\n" + gz.String()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				br := bufio.NewReader(c)
+				for {
+					if _, err := http.ReadRequest(br); err != nil {
+						return
+					}
+					_, _ = c.Write([]byte(raw))
+				}
+			}()
+		}
+	}()
+	url := "http://" + l.Addr().String() + "/path"
+
+	options := *DefaultOptions
+	options.Timeout = 5 * time.Second
+	conn, err := NewClient(&options).CreateConnection(url, &options)
+	require.Nil(t, err)
+	_, clientResp, err := NewClient(&options).DoRaw(conn, "GET", url, "", nil, nil, nil)
+	require.Nil(t, err)
+	pipeline := NewPipelineClient(context.Background(), DefaultPipelineOptions)
+	defer pipeline.Close()
+	_, pipelineResp, err := pipeline.DoRaw("GET", url, "", nil, nil, nil)
+	require.Nil(t, err)
+
+	// the client keeps header keys as received, the pipelined client
+	// canonicalizes them
+	require.Equal(t, []string{"a=1"}, clientResp.Header["set-cookie"])
+	require.Equal(t, []string{"b=2"}, clientResp.Header["Set-Cookie"])
+	require.Equal(t, []string{"a=1", "b=2"}, pipelineResp.Header.Values("Set-Cookie"))
+
+	// both clients return the same response
+	for _, resp := range []*http.Response{clientResp, pipelineResp} {
+		require.Equal(t, "HTTP/1.1", resp.Proto)
+		require.Equal(t, "201 Made", resp.Status)
+		require.True(t, resp.Uncompressed)
+		require.Equal(t, "GET", resp.Request.Method)
+		require.Equal(t, url, resp.Request.URL.String())
+		body, err := io.ReadAll(resp.Body)
+		require.Nil(t, err)
+		require.Equal(t, "compressed body", string(body))
+		require.Equal(t, "set-cookie", ResponseRawHeaders(resp)[0].Key)
+		resp.Body.Close()
+	}
+
+	// no response along with an error
+	pipelineOptions := DefaultPipelineOptions
+	pipelineOptions.Host = "127.0.0.1:1"
+	pipeline = NewPipelineClient(context.Background(), pipelineOptions)
+	defer pipeline.Close()
+	_, resp, err := pipeline.DoRaw("GET", url, "", nil, nil, nil)
+	require.NotNil(t, err)
+	require.Nil(t, resp)
+}
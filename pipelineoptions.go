@@ -3,7 +3,9 @@ package pkg
 import (
 	"time"
 
+	"github.com/secoba/rawhttp/client"
 	"github.com/secoba/rawhttp/clientpipeline"
+	"github.com/secoba/rawhttp/proxy"
 )
 
 // PipelineOptions contains options for pipelined http client
@@ -15,6 +17,23 @@ type PipelineOptions struct {
 	MaxPendingRequests     int
 	AutomaticHostHeader    bool
 	AutomaticContentLength bool
+	IsTLS                  bool   // performs a tls handshake with Host, after the CONNECT when a proxy is used
+	SNI                    string // server name sent in the tls handshake
+	Proxy                  string
+	ProxyDialTimeout       time.Duration
+	ProxyChain             []string                   // ordered list of proxies tunneled hop by hop, takes precedence over Proxy
+	ProxyPool              *ProxyPool                 // rotates connections across proxies, takes precedence over Proxy and ProxyChain
+	ProxyProtocol          *proxy.ProxyProtocolHeader // PROXY protocol header written right after connecting, before tls
+	ParseMode              client.ParseMode           // rejects malformed responses when client.Strict, see ResponseAnomalies
+	HTTP09Fallback         bool                       // reads non-HTTP responses as HTTP/0.9 instead of failing with a *client.NonHTTPError
+	Limits                 client.Limits              // bounds the size of response heads and bodies, exceeding one fails with a *client.LimitError
+	Network                string                     // network dialed instead of tcp, such as unix, proxies being skipped when set
+	Address                string                     // address dialed instead of the url host, such as a socket path, proxies being skipped when set
+	// MaxIdemponentCallAttempts is the number of attempts for requests failed by
+	// a connection error, clientpipeline.DefaultMaxIdemponentCallAttempts if not set
+	MaxIdemponentCallAttempts int
+	RetryIf                   clientpipeline.RetryIfFunc // retries non idempotent requests it returns true for
+	MaxDesyncReports          int                        // connections per origin whose desync reports are kept, clientpipeline.DefaultMaxDesyncReports if not set
 }
 
 // DefaultPipelineOptions is the default options for pipelined http client
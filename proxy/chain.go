@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/projectdiscovery/fastdialer/fastdialer"
+)
+
+// HopError is returned when a single hop of a proxy chain fails. A proxy that
+// can't reach the next one of the chain reports the failure of the next one.
+type HopError struct {
+	Hop   int    // zero based index of the failing proxy in the chain
+	Proxy string // proxy url with credentials redacted
+	Err   error
+}
+
+func (e *HopError) Error() string {
+	return fmt.Sprintf("proxy hop %d (%s): %v", e.Hop, e.Proxy, e.Err)
+}
+
+func (e *HopError) Unwrap() error {
+	return e.Err
+}
+
+var errEmptyChain = errors.New("empty proxy chain")
+
+// ChainDialer returns a DialFunc that tunnels through every proxy in proxyAddrs in order.
+// The first proxy is dialed directly, every following proxy is reached through the
+// tunnel built so far and the last one connects to the target address.
+func ChainDialer(proxyAddrs []string, timeout time.Duration) DialFunc {
+	return chainDialer(proxyAddrs, timeout, nil)
+}
+
+// ChainFastDialer is like ChainDialer but dials the first proxy with fd
+func ChainFastDialer(proxyAddrs []string, timeout time.Duration, fd *fastdialer.Dialer) DialFunc {
+	return chainDialer(proxyAddrs, timeout, fd)
+}
+
+func chainDialer(proxyAddrs []string, timeout time.Duration, fd *fastdialer.Dialer) DialFunc {
+	return func(addr string) (net.Conn, error) {
+		if len(proxyAddrs) == 0 {
+			return nil, errEmptyChain
+		}
+		hops := make([]*url.URL, 0, len(proxyAddrs))
+		for i, proxyAddr := range proxyAddrs {
+			u, err := url.Parse(proxyAddr)
+			if err != nil {
+				return nil, &HopError{Hop: i, Proxy: proxyAddr, Err: err}
+			}
+			hops = append(hops, u)
+		}
+
+		conn, err := dialDirect(hostPort(hops[0]), timeout, fd)
+		if err != nil {
+			return nil, &HopError{Hop: 0, Proxy: hops[0].Redacted(), Err: err}
+		}
+		for i, u := range hops {
+			next := addr
+			if i+1 < len(hops) {
+				next = hostPort(hops[i+1])
+			}
+			if conn, err = connectThrough(conn, u, next, timeout); err != nil {
+				if i+1 < len(hops) && errors.Is(err, errTunnelRefused) {
+					// the proxy is fine, the next one is unreachable
+					return nil, &HopError{Hop: i + 1, Proxy: hops[i+1].Redacted(), Err: err}
+				}
+				return nil, &HopError{Hop: i, Proxy: u.Redacted(), Err: err}
+			}
+		}
+		return conn, nil
+	}
+}
+
+// connectThrough asks the proxy u, already reachable over conn, to open a tunnel to addr.
+// conn is closed when the handshake fails.
+func connectThrough(conn net.Conn, u *url.URL, addr string, timeout time.Duration) (net.Conn, error) {
+	var (
+		tunnel net.Conn
+		err    error
+	)
+	switch u.Scheme {
+	case "http":
+		tunnel, err = httpConnect(conn, u, addr, timeout)
+	case "https":
+		if conn, err = tlsToProxy(conn, u, timeout); err != nil {
+			return nil, err
+		}
+		tunnel, err = httpConnect(conn, u, addr, timeout)
+	case "socks4", "socks4a":
+		tunnel, err = socks4Connect(conn, u, addr, timeout)
+	case "socks5", "socks5h":
+		tunnel, err = socks5Connect(conn, u, addr, timeout)
+	default:
+		err = fmt.Errorf("unsupported proxy protocol: %s", u.Scheme)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tunnel, nil
+}
+
+func dialDirect(addr string, timeout time.Duration, fd *fastdialer.Dialer) (net.Conn, error) {
+	if fd != nil {
+		return fd.Dial(context.TODO(), "tcp", addr)
+	}
+	if timeout > 0 {
+		return net.DialTimeout("tcp", addr, timeout)
+	}
+	return net.Dial("tcp", addr)
+}
+
+// hostPort returns the proxy address adding the default port of the scheme if missing
+func hostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	switch u.Scheme {
+	case "socks4", "socks4a", "socks5", "socks5h":
+		return net.JoinHostPort(u.Hostname(), "1080")
+	case "https":
+		return net.JoinHostPort(u.Hostname(), "443")
+	default:
+		return net.JoinHostPort(u.Hostname(), "80")
+	}
+}
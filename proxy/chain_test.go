@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChainDialer(t *testing.T) {
+	target := echoServer(t)
+	httpProxy := "http://" + connectProxy(t)
+	socksProxy := "socks5://" + socks5Proxy(t)
+
+	chains := map[string][]string{
+		"http":                       {httpProxy},
+		"socks5":                     {socksProxy},
+		"http->socks5":               {httpProxy, socksProxy},
+		"socks5->http":               {socksProxy, httpProxy},
+		"http->socks5->http->socks5": {httpProxy, socksProxy, httpProxy, socksProxy},
+		"https->socks4a->http":       {"https://" + connectTLSProxy(t), "socks4a://" + socks4Proxy(t), httpProxy},
+	}
+	for name, chain := range chains {
+		t.Run(name, func(t *testing.T) {
+			conn, err := ChainDialer(chain, 5*time.Second)(target)
+			require.Nil(t, err)
+			requireEcho(t, conn)
+		})
+	}
+}
+
+func TestChainDialerHopError(t *testing.T) {
+	// nothing listens on the dead hop
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	dead := "socks5://" + l.Addr().String()
+	l.Close()
+
+	// every kind of proxy reports the next hop unreachable
+	for _, first := range []string{"http://" + connectProxy(t), "socks5://" + socks5Proxy(t), "socks4a://" + socks4Proxy(t)} {
+		_, err = ChainDialer([]string{first, dead}, 5*time.Second)(echoServer(t))
+		var hopErr *HopError
+		require.True(t, errors.As(err, &hopErr), err)
+		require.Equal(t, 1, hopErr.Hop, first)
+		require.Equal(t, dead, hopErr.Proxy)
+	}
+
+	// the last proxy failing to reach the target
+	httpProxy := "http://" + connectProxy(t)
+	_, err = ChainDialer([]string{httpProxy, httpProxy}, 5*time.Second)(l.Addr().String())
+	var hopErr *HopError
+	require.True(t, errors.As(err, &hopErr), err)
+	require.Equal(t, 1, hopErr.Hop)
+
+	_, err = ChainDialer([]string{dead, httpProxy}, 5*time.Second)(echoServer(t))
+	require.True(t, errors.As(err, &hopErr), err)
+	require.Equal(t, 0, hopErr.Hop)
+
+	_, err = ChainDialer([]string{"ftp://127.0.0.1:1"}, time.Second)("127.0.0.1:1")
+	require.True(t, errors.As(err, &hopErr), err)
+}
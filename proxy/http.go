@@ -2,11 +2,11 @@ package proxy
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/base64"
 	"fmt"
 	"net"
 	"net/url"
-	"strings"
 	"time"
 
 	"github.com/projectdiscovery/fastdialer/fastdialer"
@@ -15,59 +15,116 @@ import (
 
 func httpDialer(proxyAddr string, timeout time.Duration, fd *fastdialer.Dialer) DialFunc {
 	return func(addr string) (net.Conn, error) {
-		var netConn net.Conn
-		var err error
-		var auth string
-		// close the connection when an error occurs
-		defer func() {
-			if err != nil && netConn != nil {
-				netConn.Close()
-			}
-		}()
 		u, err := url.Parse(proxyAddr)
 		if err != nil {
 			return nil, err
 		}
-		if strings.Contains(proxyAddr, "@") {
-			split := strings.Split(proxyAddr, "@")
-			auth = base64.StdEncoding.EncodeToString([]byte(split[0]))
-			proxyAddr = split[1]
-		}
+		var netConn net.Conn
 		if fd != nil {
 			netConn, err = fd.Dial(context.TODO(), "tcp", u.Host)
 		} else {
 			netConn, err = net.Dial("tcp", u.Host)
 		}
-
-		//_ = netConn.SetDeadline(time.Now().Add(timeout))
-		//_ = netConn.SetReadDeadline(time.Now().Add(timeout))
-		//_ = netConn.SetWriteDeadline(time.Now().Add(timeout))
-
 		if err != nil {
 			return nil, err
 		}
-		conn := client.NewClient(netConn)
+		return httpConnect(netConn, u, addr, timeout)
+	}
+}
 
-		req := "CONNECT " + addr + " HTTP/1.1\r\n"
-		if auth != "" {
-			req += "Proxy-Authorization: Basic " + auth + "\r\n"
+func httpsDialer(proxyAddr string, timeout time.Duration, fd *fastdialer.Dialer) DialFunc {
+	return func(addr string) (net.Conn, error) {
+		u, err := url.Parse(proxyAddr)
+		if err != nil {
+			return nil, err
 		}
-		req += "\r\n"
-		clientReq := &client.Request{
-			RawBytes: []byte(req),
+		netConn, err := dialDirect(hostPort(u), timeout, fd)
+		if err != nil {
+			return nil, err
 		}
-		if err = conn.WriteRequest(clientReq); err != nil {
+		if netConn, err = tlsToProxy(netConn, u, timeout); err != nil {
 			return nil, err
 		}
-		resp, err := conn.ReadResponse(false)
+		return httpConnect(netConn, u, addr, timeout)
+	}
+}
+
+// tlsToProxy performs the tls handshake with the proxy itself, closing netConn on failure
+func tlsToProxy(netConn net.Conn, u *url.URL, timeout time.Duration) (net.Conn, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	tlsConn := tls.Client(netConn, &tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         u.Hostname(),
+	})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// httpConnect issues a CONNECT for addr to the http proxy u over netConn.
+// netConn is closed when the tunnel can't be established.
+func httpConnect(netConn net.Conn, u *url.URL, addr string, timeout time.Duration) (net.Conn, error) {
+	var err error
+	// close the connection when an error occurs
+	defer func() {
 		if err != nil {
-			return nil, err
+			netConn.Close()
 		}
-		if resp.Status.Code != 200 {
-			return nil, fmt.Errorf("could not connect to proxy: %s status code: %d", proxyAddr, resp.Status.Code)
+	}()
+	if timeout > 0 {
+		_ = netConn.SetDeadline(time.Now().Add(timeout))
+		defer netConn.SetDeadline(time.Time{}) //nolint
+	}
+	conn := client.NewClient(netConn)
+
+	req := "CONNECT " + addr + " HTTP/1.1\r\n"
+	if auth := basicAuth(u); auth != "" {
+		req += "Proxy-Authorization: " + auth + "\r\n"
+	}
+	req += "\r\n"
+	clientReq := &client.Request{
+		RawBytes: []byte(req),
+	}
+	if err = conn.WriteRequest(clientReq); err != nil {
+		return nil, err
+	}
+	resp, err := conn.ReadResponse(false)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status.Code != 200 {
+		err = fmt.Errorf("could not connect to proxy: %s status code: %d", u.Host, resp.Status.Code)
+		if resp.Status.Code != 407 {
+			err = fmt.Errorf("%w: %w", err, errTunnelRefused)
 		}
+		return nil, err
+	}
+
+	return netConn, nil
+}
 
-		return netConn, nil
+// forwardDialer connects to the proxy itself; the request sent over the
+// connection is expected to carry an absolute-form request-target.
+func forwardDialer(proxyAddr string, timeout time.Duration, fd *fastdialer.Dialer) DialFunc {
+	return func(addr string) (net.Conn, error) {
+		u, err := url.Parse(proxyAddr)
+		if err != nil {
+			return nil, err
+		}
+		if fd != nil {
+			return fd.Dial(context.TODO(), "tcp", u.Host)
+		}
+		if timeout > 0 {
+			return net.DialTimeout("tcp", u.Host, timeout)
+		}
+		return net.Dial("tcp", u.Host)
 	}
 }
 
@@ -78,3 +135,41 @@ func HTTPDialer(proxyAddr string, timeout time.Duration) DialFunc {
 func HTTPFastDialer(proxyAddr string, timeout time.Duration, fd *fastdialer.Dialer) DialFunc {
 	return httpDialer(proxyAddr, timeout, fd)
 }
+
+// HTTPSDialer returns a dialer for https:// proxies, the CONNECT is sent over tls to the proxy
+func HTTPSDialer(proxyAddr string, timeout time.Duration) DialFunc {
+	return httpsDialer(proxyAddr, timeout, nil)
+}
+
+// HTTPSFastDialer is like HTTPSDialer but dials the proxy with fd
+func HTTPSFastDialer(proxyAddr string, timeout time.Duration, fd *fastdialer.Dialer) DialFunc {
+	return httpsDialer(proxyAddr, timeout, fd)
+}
+
+// HTTPForwardDialer returns a dialer for plain-HTTP forward proxying (no CONNECT)
+func HTTPForwardDialer(proxyAddr string, timeout time.Duration) DialFunc {
+	return forwardDialer(proxyAddr, timeout, nil)
+}
+
+// HTTPForwardFastDialer returns a fastdialer backed dialer for plain-HTTP forward proxying (no CONNECT)
+func HTTPForwardFastDialer(proxyAddr string, timeout time.Duration, fd *fastdialer.Dialer) DialFunc {
+	return forwardDialer(proxyAddr, timeout, fd)
+}
+
+// ProxyAuthorization returns the Proxy-Authorization header value for the
+// credentials embedded in proxyAddr, or an empty string if there are none.
+func ProxyAuthorization(proxyAddr string) string {
+	u, err := url.Parse(proxyAddr)
+	if err != nil {
+		return ""
+	}
+	return basicAuth(u)
+}
+
+func basicAuth(u *url.URL) string {
+	if u.User == nil {
+		return ""
+	}
+	password, _ := u.User.Password()
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(u.User.Username()+":"+password))
+}
@@ -1,7 +1,12 @@
 package proxy
 
 import (
+	"errors"
 	"net"
 )
 
 type DialFunc func(addr string) (net.Conn, error)
+
+// errTunnelRefused marks a proxy answering that it could not reach the address
+// of the tunnel, the failure lying behind the proxy
+var errTunnelRefused = errors.New("tunnel refused")
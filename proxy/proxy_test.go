@@ -0,0 +1,248 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// listen starts a local listener serving every connection with handle
+func listen(t *testing.T, handle func(net.Conn)) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	return serve(t, l, handle)
+}
+
+// listenTLS is like listen but terminates tls with a self-signed certificate first
+func listenTLS(t *testing.T, handle func(net.Conn)) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.Nil(t, err)
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	require.Nil(t, err)
+	return serve(t, l, handle)
+}
+
+func serve(t *testing.T, l net.Listener, handle func(net.Conn)) string {
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handle(c)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func pipe(c, upstream net.Conn) {
+	go func() {
+		_, _ = io.Copy(upstream, c)
+		upstream.Close()
+	}()
+	_, _ = io.Copy(c, upstream)
+	c.Close()
+}
+
+func echoServer(t *testing.T) string {
+	return listen(t, func(c net.Conn) {
+		_, _ = io.Copy(c, c)
+		c.Close()
+	})
+}
+
+// connectProxy is a stand-in http CONNECT proxy
+func connectProxy(t *testing.T) string {
+	return listen(t, handleConnect)
+}
+
+// connectTLSProxy is a stand-in https CONNECT proxy
+func connectTLSProxy(t *testing.T) string {
+	return listenTLS(t, handleConnect)
+}
+
+func handleConnect(c net.Conn) {
+	br := bufio.NewReader(c)
+	req, err := http.ReadRequest(br)
+	if err != nil || req.Method != http.MethodConnect {
+		c.Close()
+		return
+	}
+	upstream, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		_, _ = c.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+		c.Close()
+		return
+	}
+	_, _ = c.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	pipe(c, upstream)
+}
+
+// socks5Proxy is a stand-in no-auth SOCKS5 proxy supporting CONNECT
+func socks5Proxy(t *testing.T) string {
+	return listen(t, func(c net.Conn) {
+		br := bufio.NewReader(c)
+		head := make([]byte, 2)
+		if _, err := io.ReadFull(br, head); err != nil {
+			c.Close()
+			return
+		}
+		if _, err := io.ReadFull(br, make([]byte, head[1])); err != nil {
+			c.Close()
+			return
+		}
+		_, _ = c.Write([]byte{5, 0})
+		req := make([]byte, 4)
+		if _, err := io.ReadFull(br, req); err != nil {
+			c.Close()
+			return
+		}
+		var host string
+		switch req[3] {
+		case 1:
+			ip := make([]byte, 4)
+			_, _ = io.ReadFull(br, ip)
+			host = net.IP(ip).String()
+		case 3:
+			l, _ := br.ReadByte()
+			name := make([]byte, l)
+			_, _ = io.ReadFull(br, name)
+			host = string(name)
+		}
+		port := make([]byte, 2)
+		_, _ = io.ReadFull(br, port)
+		upstream, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+		if err != nil {
+			_, _ = c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+			c.Close()
+			return
+		}
+		_, _ = c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		pipe(c, upstream)
+	})
+}
+
+// socks4Proxy is a stand-in SOCKS4/4a proxy supporting CONNECT
+func socks4Proxy(t *testing.T) string {
+	return listen(t, func(c net.Conn) {
+		br := bufio.NewReader(c)
+		req := make([]byte, 8)
+		if _, err := io.ReadFull(br, req); err != nil || req[0] != 4 || req[1] != 1 {
+			c.Close()
+			return
+		}
+		if _, err := br.ReadBytes(0); err != nil {
+			c.Close()
+			return
+		}
+		host := net.IP(req[4:8]).String()
+		if req[4] == 0 && req[5] == 0 && req[6] == 0 && req[7] != 0 {
+			name, err := br.ReadBytes(0)
+			if err != nil {
+				c.Close()
+				return
+			}
+			host = string(name[:len(name)-1])
+		}
+		port := strconv.Itoa(int(binary.BigEndian.Uint16(req[2:4])))
+		upstream, err := net.Dial("tcp", net.JoinHostPort(host, port))
+		if err != nil {
+			_, _ = c.Write([]byte{0, 91, 0, 0, 0, 0, 0, 0})
+			c.Close()
+			return
+		}
+		_, _ = c.Write([]byte{0, 90, 0, 0, 0, 0, 0, 0})
+		pipe(c, upstream)
+	})
+}
+
+// silentProxy accepts connections and never answers
+func silentProxy(t *testing.T) string {
+	return listen(t, func(c net.Conn) {
+		_, _ = io.Copy(io.Discard, c)
+	})
+}
+
+func requireEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err := conn.Write([]byte("ping"))
+	require.Nil(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.Nil(t, err)
+	require.Equal(t, "ping", string(buf))
+}
+
+func TestDialers(t *testing.T) {
+	target := echoServer(t)
+	_, port, _ := net.SplitHostPort(target)
+	timeout := 5 * time.Second
+
+	dialers := map[string]struct {
+		dial DialFunc
+		addr string
+	}{
+		"http":           {HTTPDialer("http://"+connectProxy(t), timeout), target},
+		"https":          {HTTPSDialer("https://"+connectTLSProxy(t), timeout), target},
+		"socks4":         {Socks4Dialer("socks4://"+socks4Proxy(t), timeout), target},
+		"socks4 resolve": {Socks4Dialer("socks4://"+socks4Proxy(t), timeout), net.JoinHostPort("localhost", port)},
+		"socks4a":        {Socks4Dialer("socks4a://user@"+socks4Proxy(t), timeout), net.JoinHostPort("localhost", port)},
+		"socks5":         {Socks5Dialer("socks5://"+socks5Proxy(t), timeout), target},
+	}
+	for name, d := range dialers {
+		t.Run(name, func(t *testing.T) {
+			conn, err := d.dial(d.addr)
+			require.Nil(t, err)
+			requireEcho(t, conn)
+		})
+	}
+}
+
+func TestDialersTimeout(t *testing.T) {
+	proxyAddr := silentProxy(t)
+	timeout := 200 * time.Millisecond
+
+	dialers := map[string]DialFunc{
+		"http":    HTTPDialer("http://"+proxyAddr, timeout),
+		"https":   HTTPSDialer("https://"+proxyAddr, timeout),
+		"socks4a": Socks4Dialer("socks4a://"+proxyAddr, timeout),
+		"socks5":  Socks5Dialer("socks5://"+proxyAddr, timeout),
+	}
+	for name, dial := range dialers {
+		t.Run(name, func(t *testing.T) {
+			start := time.Now()
+			_, err := dial("127.0.0.1:1")
+			require.NotNil(t, err)
+			require.Less(t, time.Since(start), 5*time.Second)
+		})
+	}
+}
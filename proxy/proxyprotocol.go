@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// proxyProtocolSignature starts every PROXY protocol v2 header
+var proxyProtocolSignature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	proxyProtocolV2Local = 0x20
+	proxyProtocolV2Proxy = 0x21
+
+	proxyProtocolV2Unspec = 0x00
+	proxyProtocolV2TCP4   = 0x11
+	proxyProtocolV2TCP6   = 0x21
+)
+
+var (
+	errProxyProtocolVersion  = errors.New("proxy protocol: version must be 1 or 2")
+	errProxyProtocolV1TLVs   = errors.New("proxy protocol: TLVs require version 2")
+	errProxyProtocolFamilies = errors.New("proxy protocol: source and destination address families differ")
+)
+
+// TLV is a type-length-value extension of a PROXY protocol v2 header
+type TLV struct {
+	Type  byte
+	Value []byte
+}
+
+// ProxyProtocolHeader is a HAProxy PROXY protocol preamble sent before any
+// other byte on a connection, see https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt
+type ProxyProtocolHeader struct {
+	Version     int          // 1 for the text format, 2 for the binary one
+	Local       bool         // v2 LOCAL command, the receiver ignores the addresses
+	Source      *net.TCPAddr // defaults to the local address of the connection
+	Destination *net.TCPAddr // defaults to the remote address of the connection
+	TLVs        []TLV        // v2 only
+}
+
+// Bytes serializes the header, nil addresses are sent as UNKNOWN (v1) or UNSPEC (v2)
+func (h *ProxyProtocolHeader) Bytes() ([]byte, error) {
+	switch h.Version {
+	case 1:
+		return h.v1()
+	case 2:
+		return h.v2()
+	default:
+		return nil, errProxyProtocolVersion
+	}
+}
+
+func (h *ProxyProtocolHeader) v1() ([]byte, error) {
+	if len(h.TLVs) > 0 {
+		return nil, errProxyProtocolV1TLVs
+	}
+	if h.Source == nil || h.Destination == nil {
+		return []byte("PROXY UNKNOWN\r\n"), nil
+	}
+	family := "TCP4"
+	src, dst := h.Source.IP.To4(), h.Destination.IP.To4()
+	if src == nil || dst == nil {
+		if src != nil || dst != nil {
+			return nil, errProxyProtocolFamilies
+		}
+		family = "TCP6"
+		src, dst = h.Source.IP, h.Destination.IP
+	}
+	return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", family, src, dst, h.Source.Port, h.Destination.Port)), nil
+}
+
+func (h *ProxyProtocolHeader) v2() ([]byte, error) {
+	var addrs bytes.Buffer
+	family := byte(proxyProtocolV2Unspec)
+	if h.Source != nil && h.Destination != nil {
+		src, dst := h.Source.IP.To4(), h.Destination.IP.To4()
+		family = proxyProtocolV2TCP4
+		if src == nil || dst == nil {
+			if src != nil || dst != nil {
+				return nil, errProxyProtocolFamilies
+			}
+			family = proxyProtocolV2TCP6
+			src, dst = h.Source.IP.To16(), h.Destination.IP.To16()
+		}
+		addrs.Write(src)
+		addrs.Write(dst)
+		_ = binary.Write(&addrs, binary.BigEndian, uint16(h.Source.Port))
+		_ = binary.Write(&addrs, binary.BigEndian, uint16(h.Destination.Port))
+	}
+	for _, tlv := range h.TLVs {
+		addrs.WriteByte(tlv.Type)
+		_ = binary.Write(&addrs, binary.BigEndian, uint16(len(tlv.Value)))
+		addrs.Write(tlv.Value)
+	}
+
+	command := byte(proxyProtocolV2Proxy)
+	if h.Local {
+		command = proxyProtocolV2Local
+	}
+	var b bytes.Buffer
+	b.Write(proxyProtocolSignature)
+	b.WriteByte(command)
+	b.WriteByte(family)
+	_ = binary.Write(&b, binary.BigEndian, uint16(addrs.Len()))
+	b.Write(addrs.Bytes())
+	return b.Bytes(), nil
+}
+
+// WriteProxyProtocol writes the PROXY protocol header h to conn, filling missing
+// addresses from the connection itself
+func WriteProxyProtocol(conn net.Conn, h *ProxyProtocolHeader) error {
+	header := *h
+	if header.Source == nil {
+		header.Source, _ = conn.LocalAddr().(*net.TCPAddr)
+	}
+	if header.Destination == nil {
+		header.Destination, _ = conn.RemoteAddr().(*net.TCPAddr)
+	}
+	b, err := header.Bytes()
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(b)
+	return err
+}
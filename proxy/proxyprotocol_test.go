@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyProtocolHeader(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 56324}
+	dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 443}
+	src6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1}
+	dst6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 2}
+
+	tests := []struct {
+		name   string
+		header ProxyProtocolHeader
+		result string
+		err    bool
+	}{
+		{"v1 tcp4", ProxyProtocolHeader{Version: 1, Source: src, Destination: dst}, "PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\r\n", false},
+		{"v1 tcp6", ProxyProtocolHeader{Version: 1, Source: src6, Destination: dst6}, "PROXY TCP6 2001:db8::1 2001:db8::2 1 2\r\n", false},
+		{"v1 unknown", ProxyProtocolHeader{Version: 1}, "PROXY UNKNOWN\r\n", false},
+		{"v1 mixed families", ProxyProtocolHeader{Version: 1, Source: src, Destination: dst6}, "", true},
+		{"v1 tlvs", ProxyProtocolHeader{Version: 1, TLVs: []TLV{{Type: 1}}}, "", true},
+		{"v2 tcp4", ProxyProtocolHeader{Version: 2, Source: src, Destination: dst},
+			"\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x0c\xc0\xa8\x00\x01\x0a\x00\x00\x01\xdc\x04\x01\xbb", false},
+		{"v2 local tlv", ProxyProtocolHeader{Version: 2, Local: true, TLVs: []TLV{{Type: 0x02, Value: []byte("ab")}}},
+			"\r\n\r\n\x00\r\nQUIT\n\x20\x00\x00\x05\x02\x00\x02ab", false},
+		{"invalid version", ProxyProtocolHeader{Version: 3}, "", true},
+	}
+	for _, test := range tests {
+		b, err := test.header.Bytes()
+		require.Equal(t, test.err, err != nil, test.name)
+		require.Equal(t, test.result, string(b), test.name)
+	}
+}
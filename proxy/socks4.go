@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/projectdiscovery/fastdialer/fastdialer"
+)
+
+const (
+	socks4Version       = 4
+	socks4CmdConnect    = 1
+	socks4ReplyGranted  = 90
+	socks4ReplyRejected = 91
+)
+
+var errSocks4IPv6 = errors.New("socks4: ipv6 destinations are not supported")
+
+// Socks4Dialer returns a dialer for socks4:// and socks4a:// proxies.
+// With socks4 the target host is resolved locally, with socks4a the proxy resolves it.
+func Socks4Dialer(proxyAddr string, timeout time.Duration) DialFunc {
+	return socks4Dialer(proxyAddr, timeout, nil)
+}
+
+// Socks4FastDialer is like Socks4Dialer but dials the proxy with fd
+func Socks4FastDialer(proxyAddr string, timeout time.Duration, fd *fastdialer.Dialer) DialFunc {
+	return socks4Dialer(proxyAddr, timeout, fd)
+}
+
+func socks4Dialer(proxyAddr string, timeout time.Duration, fd *fastdialer.Dialer) DialFunc {
+	return func(addr string) (net.Conn, error) {
+		u, err := url.Parse(proxyAddr)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := dialDirect(hostPort(u), timeout, fd)
+		if err != nil {
+			return nil, err
+		}
+		return socks4Connect(conn, u, addr, timeout)
+	}
+}
+
+// socks4Connect performs the SOCKS4/4a CONNECT handshake for addr over conn.
+// conn is closed when the tunnel can't be established.
+func socks4Connect(conn net.Conn, u *url.URL, addr string, timeout time.Duration) (net.Conn, error) {
+	var err error
+	defer func() {
+		if err != nil {
+			conn.Close()
+		}
+	}()
+	if timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(timeout))
+		defer conn.SetDeadline(time.Time{}) //nolint
+	}
+
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("socks4: invalid port %q", portStr)
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil && u.Scheme == "socks4" {
+		ctx := context.Background()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		var ips []net.IP
+		if ips, err = net.DefaultResolver.LookupIP(ctx, "ip4", host); err != nil {
+			return nil, err
+		}
+		ip = ips[0]
+	}
+
+	req := []byte{socks4Version, socks4CmdConnect, 0, 0}
+	binary.BigEndian.PutUint16(req[2:], uint16(port))
+	switch {
+	case ip == nil:
+		// socks4a: an invalid ip 0.0.0.x asks the proxy to resolve the host name
+		req = append(req, 0, 0, 0, 1)
+	case ip.To4() == nil:
+		err = errSocks4IPv6
+		return nil, err
+	default:
+		req = append(req, ip.To4()...)
+	}
+	if u.User != nil {
+		req = append(req, u.User.Username()...)
+	}
+	req = append(req, 0)
+	if ip == nil {
+		req = append(req, host...)
+		req = append(req, 0)
+	}
+	if _, err = conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	reply := make([]byte, 8)
+	if _, err = io.ReadFull(conn, reply); err != nil {
+		return nil, err
+	}
+	if reply[0] != 0 {
+		err = fmt.Errorf("socks4: invalid reply version %d", reply[0])
+		return nil, err
+	}
+	if reply[1] == socks4ReplyRejected {
+		err = fmt.Errorf("socks4: request rejected with code %d: %w", reply[1], errTunnelRefused)
+		return nil, err
+	}
+	if reply[1] != socks4ReplyGranted {
+		err = fmt.Errorf("socks4: request rejected with code %d", reply[1])
+		return nil, err
+	}
+	return conn, nil
+}
@@ -1,8 +1,11 @@
 package proxy
 
 import (
+	"context"
+	"fmt"
 	"net"
 	"net/url"
+	"strings"
 	"time"
 
 	p "golang.org/x/net/proxy"
@@ -15,13 +18,63 @@ func Socks5Dialer(proxyAddr string, timeout time.Duration) DialFunc {
 		dialer p.Dialer
 	)
 	if u, err = url.Parse(proxyAddr); err == nil {
-		dialer, err = p.FromURL(u, p.Direct)
-		//dialer, err = p.SOCKS5("tcp", proxyAddr, nil, &net.Dialer{Timeout: timeout * time.Second})
+		var forward p.Dialer = p.Direct
+		if timeout > 0 {
+			forward = &net.Dialer{Timeout: timeout}
+		}
+		dialer, err = p.FromURL(u, forward)
 	}
 	return func(addr string) (net.Conn, error) {
 		if err != nil {
 			return nil, err
 		}
+		if timeout > 0 {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			return dialer.(p.ContextDialer).DialContext(ctx, "tcp", addr)
+		}
 		return dialer.Dial("tcp", addr)
 	}
 }
+
+// socks5Connect performs the SOCKS5 handshake for addr with the proxy u over an
+// already established conn.
+func socks5Connect(conn net.Conn, u *url.URL, addr string, timeout time.Duration) (net.Conn, error) {
+	dialer, err := p.FromURL(u, &connDialer{conn: conn})
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	tunnel, err := dialer.(p.ContextDialer).DialContext(ctx, "tcp", addr)
+	if err != nil && isSocks5Refusal(err) {
+		err = fmt.Errorf("%w: %w", err, errTunnelRefused)
+	}
+	return tunnel, err
+}
+
+// socks5Refusals are the replies of a proxy failing to reach the address asked
+// for, as reported by x/net
+var socks5Refusals = []string{"network unreachable", "host unreachable", "connection refused", "TTL expired"}
+
+func isSocks5Refusal(err error) bool {
+	for _, refusal := range socks5Refusals {
+		if strings.HasSuffix(err.Error(), "unknown error "+refusal) {
+			return true
+		}
+	}
+	return false
+}
+
+// connDialer hands out an existing connection instead of dialing a new one
+type connDialer struct {
+	conn net.Conn
+}
+
+func (d *connDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.conn, nil
+}
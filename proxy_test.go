@@ -0,0 +1,115 @@
+package pkg
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// forwardProxyServer is a stand-in forward proxy answering each request with
+// its request-target and Proxy-Authorization values instead of forwarding it
+func forwardProxyServer(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				br := bufio.NewReader(c)
+				for {
+					req, err := http.ReadRequest(br)
+					if err != nil {
+						return
+					}
+					_, _ = io.Copy(io.Discard, req.Body)
+					body := req.RequestURI + "|" + strings.Join(req.Header.Values("Proxy-Authorization"), ",")
+					_, _ = fmt.Fprintf(c, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestForwardProxy(t *testing.T) {
+	proxyAddr := forwardProxyServer(t)
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:pass"))
+	const target = "http://example.com:8080/path?q=1"
+
+	tests := []struct {
+		name    string
+		raw     string
+		skipRaw bool
+		want    string
+	}{
+		{name: "structured", want: target + "|" + auth},
+		{name: "raw", raw: "GET /raw HTTP/1.1\r\n\r\n", want: "http://example.com:8080/raw|" + auth},
+		{name: "raw with proxy authorization", raw: "GET /raw HTTP/1.1\r\nProxy-Authorization: Basic other\r\n\r\n", want: "http://example.com:8080/raw|Basic other"},
+		{name: "raw skipped", raw: "GET /raw HTTP/1.1\r\n\r\n", skipRaw: true, want: "/raw|"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := *DefaultOptions
+			options.Timeout = 5 * time.Second
+			options.Proxy = "http://user:pass@" + proxyAddr
+			options.ForwardProxy = true
+			options.ForwardProxySkipRaw = test.skipRaw
+			c := NewClient(&options)
+
+			conn, err := c.CreateConnection(target, &options)
+			require.Nil(t, err)
+			var raw []byte
+			if test.raw != "" {
+				raw = []byte(test.raw)
+			}
+			_, resp, err := c.DoRaw(conn, "GET", target, "", nil, nil, raw)
+			require.Nil(t, err)
+			body, err := io.ReadAll(resp.Body)
+			require.Nil(t, err)
+			require.Equal(t, test.want, string(body))
+		})
+	}
+}
+
+func TestClientResponseProxy(t *testing.T) {
+	proxyAddr := forwardProxyServer(t)
+	proxyURL := "http://" + proxyAddr
+	options := *DefaultOptions
+	options.Timeout = 5 * time.Second
+	options.Proxy = proxyURL
+	options.ForwardProxy = true
+	c := NewClient(&options)
+
+	conn, err := c.CreateConnection("http://example.com/", &options)
+	require.Nil(t, err)
+	_, resp, err := c.DoRaw(conn, "GET", "http://example.com/", "", nil, nil, nil)
+	require.Nil(t, err)
+	// the metadata survives the body being wrapped
+	resp.Body = io.NopCloser(resp.Body)
+	require.Equal(t, proxyURL, ResponseProxy(resp))
+	require.NotEmpty(t, ResponseRawHeaders(resp))
+	resp.Body.Close()
+
+	// the stand-in answers direct requests too
+	direct := options
+	direct.Proxy = ""
+	conn, err = c.CreateConnection("http://"+proxyAddr+"/", &direct)
+	require.Nil(t, err)
+	_, resp, err = c.DoRawWithOptions(conn, "GET", "http://"+proxyAddr+"/", "", nil, nil, nil, &direct)
+	require.Nil(t, err)
+	require.Empty(t, ResponseProxy(resp))
+	resp.Body.Close()
+}
@@ -0,0 +1,286 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/secoba/rawhttp/clientpipeline"
+	"github.com/secoba/rawhttp/proxy"
+)
+
+// ProxySelection is the strategy used by a ProxyPool to pick a proxy
+type ProxySelection int
+
+const (
+	// RoundRobin cycles through the healthy proxies in order
+	RoundRobin ProxySelection = iota
+	// Random picks a random healthy proxy for every connection
+	Random
+	// StickyPerHost keeps using the same proxy for a host until it is quarantined
+	StickyPerHost
+)
+
+// DefaultProbeInterval is the interval between health probes of quarantined proxies
+const DefaultProbeInterval = 30 * time.Second
+
+// DefaultProbeTimeout is the timeout of a single health probe
+const DefaultProbeTimeout = 10 * time.Second
+
+// ErrNoHealthyProxy is returned when every proxy of the pool is quarantined
+var ErrNoHealthyProxy = errors.New("no healthy proxy available in pool")
+
+// ProxyPool spreads connections across a set of proxies. Proxies failing to
+// connect are quarantined and probed in the background until they recover.
+type ProxyPool struct {
+	Selection     ProxySelection
+	ProbeInterval time.Duration // defaults to DefaultProbeInterval
+	ProbeTimeout  time.Duration // defaults to DefaultProbeTimeout
+	// ProbeTarget is the host:port a quarantined proxy is asked to connect to,
+	// when empty a plain tcp connection to the proxy is attempted instead.
+	ProbeTarget string
+
+	mu          sync.Mutex
+	proxies     []*poolProxy
+	next        int
+	sticky      map[string]*poolProxy
+	rand        *rand.Rand
+	probing     bool
+	stopCh      chan struct{}
+	stopOnce    sync.Once
+	probeDoneCh chan struct{}
+}
+
+type poolProxy struct {
+	url         string
+	quarantined bool
+}
+
+// NewProxyPool creates a pool rotating across proxies with the given selection strategy
+func NewProxyPool(proxies []string, selection ProxySelection) *ProxyPool {
+	p := &ProxyPool{
+		Selection: selection,
+		sticky:    make(map[string]*poolProxy),
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		stopCh:    make(chan struct{}),
+	}
+	for _, proxyURL := range proxies {
+		p.proxies = append(p.proxies, &poolProxy{url: proxyURL})
+	}
+	return p
+}
+
+// Pick returns the proxy to use for a connection to host
+func (p *ProxyPool) Pick(host string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.Selection == StickyPerHost {
+		if pp, ok := p.sticky[host]; ok && !pp.quarantined {
+			return pp.url, nil
+		}
+	}
+
+	var healthy []*poolProxy
+	for _, pp := range p.proxies {
+		if !pp.quarantined {
+			healthy = append(healthy, pp)
+		}
+	}
+	if len(healthy) == 0 {
+		return "", ErrNoHealthyProxy
+	}
+
+	var pp *poolProxy
+	switch p.Selection {
+	case Random:
+		pp = healthy[p.rand.Intn(len(healthy))]
+	default:
+		pp = healthy[p.next%len(healthy)]
+		p.next++
+	}
+	if p.Selection == StickyPerHost {
+		p.sticky[host] = pp
+	}
+	return pp.url, nil
+}
+
+// Quarantine removes proxyURL from rotation until a background probe succeeds
+func (p *ProxyPool) Quarantine(proxyURL string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, pp := range p.proxies {
+		if pp.url == proxyURL {
+			pp.quarantined = true
+		}
+	}
+	if !p.probing {
+		p.probing = true
+		p.probeDoneCh = make(chan struct{})
+		go p.probe(p.probeDoneCh)
+	}
+}
+
+// Healthy returns the proxies currently in rotation
+func (p *ProxyPool) Healthy() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var healthy []string
+	for _, pp := range p.proxies {
+		if !pp.quarantined {
+			healthy = append(healthy, pp.url)
+		}
+	}
+	return healthy
+}
+
+// Quarantined returns the proxies currently out of rotation
+func (p *ProxyPool) Quarantined() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var quarantined []string
+	for _, pp := range p.proxies {
+		if pp.quarantined {
+			quarantined = append(quarantined, pp.url)
+		}
+	}
+	return quarantined
+}
+
+// Close stops the background health probes
+func (p *ProxyPool) Close() {
+	p.stopOnce.Do(func() {
+		close(p.stopCh)
+	})
+	p.mu.Lock()
+	doneCh := p.probeDoneCh
+	p.mu.Unlock()
+	if doneCh != nil {
+		<-doneCh
+	}
+}
+
+// probe periodically checks quarantined proxies and puts them back in rotation,
+// it returns once no proxy is quarantined anymore
+func (p *ProxyPool) probe(doneCh chan struct{}) {
+	interval := p.ProbeInterval
+	if interval <= 0 {
+		interval = DefaultProbeInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	defer close(doneCh)
+
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		for _, proxyURL := range p.Quarantined() {
+			if p.check(proxyURL) == nil {
+				p.release(proxyURL)
+			}
+		}
+
+		p.mu.Lock()
+		if len(p.quarantinedUnlocked()) == 0 {
+			p.probing = false
+			p.mu.Unlock()
+			return
+		}
+		p.mu.Unlock()
+	}
+}
+
+func (p *ProxyPool) quarantinedUnlocked() []*poolProxy {
+	var quarantined []*poolProxy
+	for _, pp := range p.proxies {
+		if pp.quarantined {
+			quarantined = append(quarantined, pp)
+		}
+	}
+	return quarantined
+}
+
+func (p *ProxyPool) release(proxyURL string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, pp := range p.proxies {
+		if pp.url == proxyURL {
+			pp.quarantined = false
+		}
+	}
+}
+
+// check verifies proxyURL is reachable and, if a ProbeTarget is set, able to tunnel to it
+func (p *ProxyPool) check(proxyURL string) error {
+	timeout := p.ProbeTimeout
+	if timeout <= 0 {
+		timeout = DefaultProbeTimeout
+	}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if p.ProbeTarget != "" {
+		conn, err = proxy.ChainDialer([]string{proxyURL}, timeout)(p.ProbeTarget)
+	} else {
+		var u *url.URL
+		if u, err = url.Parse(proxyURL); err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", u.Host)
+	}
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// proxyPoolDial returns a pipeline dialer connecting through proxies picked from pool
+func proxyPoolDial(pool *ProxyPool, timeout time.Duration) clientpipeline.DialFunc {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		proxyURL, err := pool.Pick(addr)
+		if err != nil {
+			return nil, err
+		}
+		dial, err := proxyDialer(proxyURL, false, timeout, nil)
+		if err != nil {
+			return nil, err
+		}
+		c, err := dialContext(ctx, dial, addr)
+		if err != nil {
+			if ctx.Err() != nil {
+				// the caller gave up, the proxy is not to blame
+				return nil, err
+			}
+			pool.Quarantine(proxyURL)
+			return nil, fmt.Errorf("%w: %w", errProxy, err)
+		}
+		return &proxiedConn{Conn: c, proxy: proxyURL}, nil
+	}
+}
+
+// proxiedConn records the proxy a connection was established through
+type proxiedConn struct {
+	net.Conn
+	proxy string
+}
+
+func (c *proxiedConn) Proxy() string {
+	return c.proxy
+}
@@ -0,0 +1,89 @@
+package pkg
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyPoolSelection(t *testing.T) {
+	proxies := []string{"http://127.0.0.1:1", "http://127.0.0.1:2", "http://127.0.0.1:3"}
+
+	pool := NewProxyPool(proxies, RoundRobin)
+	for i := 0; i < 6; i++ {
+		proxyURL, err := pool.Pick("example.com:80")
+		require.Nil(t, err)
+		require.Equal(t, proxies[i%len(proxies)], proxyURL)
+	}
+
+	pool = NewProxyPool(proxies, StickyPerHost)
+	first, _ := pool.Pick("a:80")
+	second, _ := pool.Pick("b:80")
+	require.NotEqual(t, first, second)
+	for i := 0; i < 3; i++ {
+		proxyURL, _ := pool.Pick("a:80")
+		require.Equal(t, first, proxyURL)
+	}
+
+	pool = NewProxyPool(proxies, Random)
+	for i := 0; i < 10; i++ {
+		proxyURL, err := pool.Pick("example.com:80")
+		require.Nil(t, err)
+		require.Contains(t, proxies, proxyURL)
+	}
+}
+
+func TestProxyPoolQuarantine(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer l.Close()
+	alive := "http://" + l.Addr().String()
+	dead := "http://127.0.0.1:1"
+
+	pool := NewProxyPool([]string{alive, dead}, StickyPerHost)
+	pool.ProbeInterval = 10 * time.Millisecond
+	defer pool.Close()
+
+	pool.Quarantine(alive)
+	pool.Quarantine(dead)
+	_, err = pool.Pick("example.com:80")
+	require.ErrorIs(t, err, ErrNoHealthyProxy)
+
+	// the live proxy gets back in rotation after being probed
+	require.Eventually(t, func() bool {
+		return len(pool.Healthy()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	proxyURL, err := pool.Pick("example.com:80")
+	require.Nil(t, err)
+	require.Equal(t, alive, proxyURL)
+	require.Equal(t, []string{dead}, pool.Quarantined())
+}
+
+func TestProxyPoolDialContext(t *testing.T) {
+	// a proxy accepting connections but never answering the CONNECT
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	pool := NewProxyPool([]string{"http://" + l.Addr().String()}, RoundRobin)
+	defer pool.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = proxyPoolDial(pool, 10*time.Second)(ctx, "example.com:80")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), 5*time.Second)
+	require.Empty(t, pool.Quarantined())
+}
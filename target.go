@@ -0,0 +1,60 @@
+package pkg
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/secoba/rawhttp/clientpipeline"
+)
+
+// unixSuffix marks the schemes of unix socket urls, such as
+// http+unix://%2Fvar%2Frun%2Fdocker.sock/v1/info
+const unixSuffix = "+unix"
+
+// unixHost names the target of requests sent to unix sockets, and the tls
+// server name of https+unix ones
+const unixHost = "localhost"
+
+// unixURL returns rawURL rewritten for the protocol it speaks, with localhost
+// as host, along with the path of the socket it targets. Other urls are
+// returned as is with an empty path.
+func unixURL(rawURL string) (string, string) {
+	scheme, rest, ok := strings.Cut(rawURL, "://")
+	if !ok || !strings.HasSuffix(strings.ToLower(scheme), unixSuffix) {
+		return rawURL, ""
+	}
+	host, path := rest, ""
+	if i := strings.IndexAny(rest, "/?#"); i >= 0 {
+		host, path = rest[:i], rest[i:]
+	}
+	socket, err := url.PathUnescape(host)
+	if err != nil {
+		socket = host
+	}
+	return scheme[:len(scheme)-len(unixSuffix)] + "://" + unixHost + path, socket
+}
+
+// dialTarget returns the network and address dialed for addr, as overridden
+// by network and address if set
+func dialTarget(network, address, addr string) (string, string) {
+	if network == "" {
+		network = "tcp"
+	}
+	if address == "" {
+		address = addr
+	}
+	return network, address
+}
+
+// targetDial returns a pipelined dialer connecting to address over network
+// instead of the address of the requests
+func targetDial(network, address string, timeout time.Duration) clientpipeline.DialFunc {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		network, address := dialTarget(network, address, addr)
+		d := net.Dialer{Timeout: timeout}
+		return d.DialContext(ctx, network, address)
+	}
+}
@@ -0,0 +1,121 @@
+package pkg
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// unixServer serves http on a unix socket, answering with the host and path,
+// followed by the tls server name when serving https
+func unixServer(t *testing.T, https bool) string {
+	// socket paths are short, t.TempDir can be too long
+	dir, err := os.MkdirTemp("", "rawhttp")
+	require.Nil(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "http.sock")
+	l, err := net.Listen("unix", socket)
+	require.Nil(t, err)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		answer := r.Host + " " + r.URL.RequestURI()
+		if r.TLS != nil {
+			answer += " " + r.TLS.ServerName
+		}
+		_, _ = io.WriteString(w, answer)
+	}))
+	server.Listener.Close()
+	server.Listener = l
+	if https {
+		server.StartTLS()
+	} else {
+		server.Start()
+	}
+	t.Cleanup(server.Close)
+	return socket
+}
+
+func TestUnixTarget(t *testing.T) {
+	socket := unixServer(t, false)
+	unixURL := "http+unix://" + url.PathEscape(socket) + "/v1/info?all=1"
+
+	t.Run("client", func(t *testing.T) {
+		options := *DefaultOptions
+		options.Timeout = 5 * time.Second
+		c := NewClient(&options)
+
+		conn, err := c.CreateConnection(unixURL, &options)
+		require.Nil(t, err)
+		_, resp, err := c.DoRaw(conn, "GET", unixURL, "", nil, nil, nil)
+		require.Nil(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.Nil(t, err)
+		require.Equal(t, "localhost /v1/info?all=1", string(body))
+
+		// network and address override, the url only naming the host
+		options.Network, options.Address = "unix", socket
+		conn, err = c.CreateConnection("http://daemon.local/ping", &options)
+		require.Nil(t, err)
+		_, resp, err = c.DoRaw(conn, "GET", "http://daemon.local/ping", "", nil, nil, nil)
+		require.Nil(t, err)
+		body, err = io.ReadAll(resp.Body)
+		require.Nil(t, err)
+		require.Equal(t, "daemon.local /ping", string(body))
+	})
+
+	t.Run("pipeline", func(t *testing.T) {
+		options := DefaultPipelineOptions
+		options.Timeout = 5 * time.Second
+		pipeline := NewPipelineClient(context.Background(), options)
+		defer pipeline.Close()
+		_, resp, err := pipeline.DoRaw("GET", unixURL, "", nil, nil, nil)
+		require.Nil(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.Nil(t, err)
+		require.Equal(t, "localhost /v1/info?all=1", string(body))
+
+		options.Network, options.Address = "unix", socket
+		pipeline = NewPipelineClient(context.Background(), options)
+		defer pipeline.Close()
+		_, resp, err = pipeline.DoRaw("GET", "http://daemon.local/ping", "", nil, nil, nil)
+		require.Nil(t, err)
+		body, err = io.ReadAll(resp.Body)
+		require.Nil(t, err)
+		require.Equal(t, "daemon.local /ping", string(body))
+	})
+}
+
+func TestUnixTargetTLS(t *testing.T) {
+	socket := unixServer(t, true)
+	unixURL := "https+unix://" + url.PathEscape(socket) + "/v1/info"
+
+	// the tls server name is the host the url is rewritten to
+	options := *DefaultOptions
+	options.Timeout = 5 * time.Second
+	c := NewClient(&options)
+	conn, err := c.CreateConnection(unixURL, &options)
+	require.Nil(t, err)
+	_, resp, err := c.DoRaw(conn, "GET", unixURL, "", nil, nil, nil)
+	require.Nil(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+	require.Equal(t, "localhost /v1/info localhost", string(body))
+
+	pipelineOptions := DefaultPipelineOptions
+	pipelineOptions.Timeout = 5 * time.Second
+	pipeline := NewPipelineClient(context.Background(), pipelineOptions)
+	defer pipeline.Close()
+	_, resp, err = pipeline.DoRaw("GET", unixURL, "", nil, nil, nil)
+	require.Nil(t, err)
+	body, err = io.ReadAll(resp.Body)
+	require.Nil(t, err)
+	require.Equal(t, "localhost /v1/info localhost", string(body))
+}
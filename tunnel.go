@@ -0,0 +1,73 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/secoba/rawhttp/client"
+)
+
+// ErrTunnelRefused is returned by DoTunnel when the server neither switches
+// protocols nor accepts the CONNECT.
+var ErrTunnelRefused = errors.New("tunnel refused")
+
+var errNotUpgradable = errors.New("connection can't be upgraded")
+
+// DoTunnel sends a request on conn and, on a 101 or a 2xx to a CONNECT, returns
+// the stream following the response head as a net.Conn, bytes already buffered
+// included, to be closed by the caller. For a CONNECT, uripath carries the
+// authority, such as "example.com:443". Otherwise the response is returned with
+// an ErrTunnelRefused, its body closing conn.
+func (c *Client) DoTunnel(conn Conn, method, url, uripath string, headers map[string][]string, body io.Reader) (*http.Response, net.Conn, error) {
+	req, _, _, err := buildRequest(method, url, uripath, headers, body, nil, c.Options)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := conn.WriteRequest(req); err != nil {
+		return nil, nil, err
+	}
+	resp, err := conn.ReadResponse(c.Options.ForceReadAllBody)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	httpReq := newHTTPRequest(method, url, headers)
+	if !isTunnel(method, resp.Status.Code) {
+		httpResp, err := toHTTPResponse(resp, conn, &responseMeta{proxy: connProxy(conn)}, httpReq)
+		return httpResp, nil, firstErr(err, fmt.Errorf("%w: status %d", ErrTunnelRefused, resp.Status.Code))
+	}
+	nc := netConn(conn)
+	if nc == nil {
+		return nil, nil, errNotUpgradable
+	}
+	// the stream goes on after the response head, possibly already buffered
+	stream := &readerConn{Conn: nc, r: resp.Stream}
+	head := *resp
+	head.Body = nil
+	httpResp, err := toHTTPResponse(&head, io.NopCloser(nil), &responseMeta{proxy: connProxy(conn)}, httpReq)
+	if err != nil {
+		return nil, nil, err
+	}
+	return httpResp, stream, nil
+}
+
+func isTunnel(method string, code int) bool {
+	if code == client.INFO_SWITCHING_PROTOCOL {
+		return true
+	}
+	return strings.EqualFold(method, http.MethodConnect) && code >= 200 && code < 300
+}
+
+// readerConn is a connection whose reads are served by r
+type readerConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *readerConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,97 @@
+package pkg
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// tunnelServer answers CONNECT with a 200, Upgrade requests with a 101 and
+// anything else with a 403. Tunnels start with a greeting sent along the head,
+// then echo.
+func tunnelServer(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				br := bufio.NewReader(c)
+				req, err := http.ReadRequest(br)
+				if err != nil {
+					return
+				}
+				switch {
+				case req.Method == http.MethodConnect:
+					// the length of a 2xx to CONNECT is meaningless
+					_, _ = io.WriteString(c, "HTTP/1.1 200 Connection established\r\nContent-Length: 100\r\n\r\ngreeting "+req.RequestURI)
+				case req.Header.Get("Upgrade") != "":
+					_, _ = io.WriteString(c, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\ngreeting "+req.RequestURI)
+				default:
+					_, _ = io.WriteString(c, "HTTP/1.1 403 Forbidden\r\nContent-Length: 2\r\n\r\nno")
+					return
+				}
+				_, _ = io.Copy(c, br)
+			}()
+		}
+	}()
+	return "http://" + l.Addr().String()
+}
+
+func TestDoTunnel(t *testing.T) {
+	url := tunnelServer(t)
+	options := *DefaultOptions
+	options.Timeout = 5 * time.Second
+	c := NewClient(&options)
+
+	tests := []struct {
+		method, uripath string
+		headers         map[string][]string
+		status          int
+	}{
+		{"CONNECT", "example.com:443", nil, 200},
+		{"GET", "/upgrade", map[string][]string{"Upgrade": {"echo"}, "Connection": {"Upgrade"}}, 101},
+	}
+	for _, test := range tests {
+		conn, err := c.CreateConnection(url, &options)
+		require.Nil(t, err)
+		resp, tunnel, err := c.DoTunnel(conn, test.method, url, test.uripath, test.headers, nil)
+		require.Nil(t, err)
+		require.Equal(t, test.status, resp.StatusCode)
+
+		greeting := "greeting " + test.uripath
+		buf := make([]byte, len(greeting))
+		_, err = io.ReadFull(tunnel, buf)
+		require.Nil(t, err)
+		require.Equal(t, greeting, string(buf))
+
+		_, err = tunnel.Write([]byte("ping"))
+		require.Nil(t, err)
+		buf = make([]byte, 4)
+		_, err = io.ReadFull(tunnel, buf)
+		require.Nil(t, err)
+		require.Equal(t, "ping", string(buf))
+		tunnel.Close()
+	}
+
+	conn, err := c.CreateConnection(url, &options)
+	require.Nil(t, err)
+	resp, tunnel, err := c.DoTunnel(conn, "GET", url, "/", nil, nil)
+	require.True(t, errors.Is(err, ErrTunnelRefused))
+	require.Nil(t, tunnel)
+	require.Equal(t, 403, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+	require.Equal(t, "no", string(body))
+}
@@ -3,15 +3,18 @@ package pkg
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
 
 	urlutil "github.com/projectdiscovery/utils/url"
 	"github.com/secoba/rawhttp/client"
+	"github.com/secoba/rawhttp/proxy"
 )
 
 // StatusError is a HTTP status error object
@@ -28,6 +31,53 @@ type readCloser struct {
 	io.Closer
 }
 
+// responseMeta is what rawhttp knows of a response beyond net/http, carried by
+// the context of its request so that it survives the body being wrapped
+type responseMeta struct {
+	proxy     string
+	attempts  int
+	headers   []client.Header // headers as received
+	anomalies []client.Anomaly
+}
+
+type responseMetaKey struct{}
+
+// metaOf returns the metadata of r, nil if r was not created by rawhttp
+func metaOf(r *http.Response) *responseMeta {
+	if r == nil || r.Request == nil {
+		return nil
+	}
+	meta, _ := r.Request.Context().Value(responseMetaKey{}).(*responseMeta)
+	return meta
+}
+
+// ResponseProxy returns the proxy a response was received through, empty if the
+// connection was direct or the response was not created by rawhttp
+func ResponseProxy(r *http.Response) string {
+	if meta := metaOf(r); meta != nil {
+		return meta.proxy
+	}
+	return ""
+}
+
+// ResponseAttempts returns the number of times the request of a pipelined
+// response was sent, 0 if the response was not created by the pipeline client
+func ResponseAttempts(r *http.Response) int {
+	if meta := metaOf(r); meta != nil {
+		return meta.attempts
+	}
+	return 0
+}
+
+// ResponseRawHeaders returns the headers of a response in the order and case
+// they were received, nil if the response was not created by rawhttp
+func ResponseRawHeaders(r *http.Response) []client.Header {
+	if meta := metaOf(r); meta != nil {
+		return meta.headers
+	}
+	return nil
+}
+
 func toRequest(method string, host, path string, query []string,
 	headers map[string][]string, body io.Reader, raw []byte, options *Options) *client.Request {
 	if headers == nil {
@@ -230,32 +280,162 @@ func toRequest(method string, host, path string, query []string,
 	}
 }
 
-func toHTTPResponse(conn Conn, resp *client.Response) (*http.Response, error) {
+// isForwardProxy returns true if requests must be sent in absolute-form to an http proxy
+func isForwardProxy(protocol string, options *Options) bool {
+	if !options.ForwardProxy || protocol != "http" || options.Proxy == "" {
+		return false
+	}
+	u, err := url.Parse(options.Proxy)
+	return err == nil && u.Scheme == "http"
+}
+
+// toForwardProxyRequest rewrites the request-target to absolute-form and adds
+// the Proxy-Authorization header required by the forward proxy
+func toForwardProxyRequest(req *client.Request, protocol, host string, options *Options) {
+	auth := proxy.ProxyAuthorization(options.Proxy)
+
+	if len(req.RawBytes) > 0 {
+		if options.ForwardProxySkipRaw {
+			return
+		}
+		firstLine, rest, _ := bytes.Cut(req.RawBytes, []byte("\n"))
+		buffer := new(bytes.Buffer)
+		parts := bytes.SplitN(firstLine, []byte(" "), 3)
+		if len(parts) == 3 && bytes.HasPrefix(parts[1], []byte("/")) {
+			parts[1] = append([]byte(protocol+"://"+host), parts[1]...)
+		}
+		buffer.Write(bytes.Join(parts, []byte(" ")))
+		buffer.WriteString("\n")
+		if auth != "" && !hasRawHeader(rest, "Proxy-Authorization") {
+			buffer.WriteString("Proxy-Authorization: " + auth + "\r\n")
+		}
+		buffer.Write(rest)
+		req.RawBytes = buffer.Bytes()
+		return
+	}
+
+	if strings.HasPrefix(req.Path, "/") {
+		req.Path = protocol + "://" + host + req.Path
+	}
+	if auth != "" {
+		for _, header := range req.Headers {
+			if strings.EqualFold(header.Key, "Proxy-Authorization") {
+				return
+			}
+		}
+		req.Headers = append(req.Headers, client.Header{Key: "Proxy-Authorization", Value: auth})
+	}
+}
+
+// hasRawHeader reports whether the header section of raw contains key
+func hasRawHeader(raw []byte, key string) bool {
+	for _, line := range bytes.Split(raw, []byte("\n")) {
+		line = bytes.TrimRight(line, "\r")
+		if len(line) == 0 {
+			break
+		}
+		if k, _, ok := bytes.Cut(line, []byte(":")); ok && strings.EqualFold(string(bytes.TrimSpace(k)), key) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResponseAnomalies returns the deviations from the response grammar the parser
+// accepted, nil if there were none or the response was not created by rawhttp
+func ResponseAnomalies(r *http.Response) []client.Anomaly {
+	if meta := metaOf(r); meta != nil {
+		return meta.anomalies
+	}
+	return nil
+}
+
+// toHTTPResponse converts a raw response to a net/http response answering req,
+// its body closing closer. Header keys are kept as received along with
+// duplicate headers, gzip bodies are decoded,
+// meta is completed with the raw headers and set on the context of the request.
+func toHTTPResponse(resp *client.Response, closer io.Closer, meta *responseMeta, req *http.Request) (*http.Response, error) {
 	rheaders := fromHeaders(resp.Headers)
 	r := http.Response{
+		Proto:         resp.Version.String(),
 		ProtoMinor:    resp.Version.Minor,
 		ProtoMajor:    resp.Version.Major,
 		Status:        resp.Status.String(),
 		StatusCode:    resp.Status.Code,
 		Header:        rheaders,
 		ContentLength: resp.ContentLength(),
+		Close:         resp.CloseRequested(),
+	}
+	if te := resp.TransferEncoding(); te != "identity" {
+		r.TransferEncoding = []string{te}
 	}
 
 	var err error
 	rbody := resp.Body
-	if headerValue(rheaders, "Content-Encoding") == "gzip" {
+	if rbody == nil {
+		rbody = http.NoBody
+	}
+	if resp.Chunked != nil {
+		r.ContentLength = -1
+		r.Trailer = make(http.Header)
+		rbody = &trailerReader{Reader: rbody, chunked: resp.Chunked, trailer: r.Trailer}
+	}
+	if strings.EqualFold(headerValue(rheaders, "Content-Encoding"), "gzip") {
 		rbody, err = gzip.NewReader(rbody)
 		if err != nil {
 			return nil, err
 		}
+		r.Uncompressed = true
+		r.ContentLength = -1
 	}
-	rc := &readCloser{rbody, conn}
+	r.Body = &readCloser{Reader: rbody, Closer: closer}
 
-	r.Body = rc
+	meta.headers = resp.Headers
+	meta.anomalies = resp.Anomalies
+	if req == nil {
+		req = &http.Request{Header: make(http.Header)}
+	}
+	r.Request = req.WithContext(context.WithValue(req.Context(), responseMetaKey{}, meta))
 
 	return &r, nil
 }
 
+// trailerReader fills trailer with the trailer fields of a chunked body once
+// it is read to the end, as net/http does, keys as received
+type trailerReader struct {
+	io.Reader
+	chunked *client.ChunkedReader
+	trailer http.Header
+	done    bool
+}
+
+func (t *trailerReader) Read(p []byte) (int, error) {
+	n, err := t.Reader.Read(p)
+	if err == io.EOF && !t.done {
+		for _, h := range t.chunked.Trailers() {
+			t.trailer[h.Key] = append(t.trailer[h.Key], h.Value)
+		}
+		t.done = true
+	}
+	return n, err
+}
+
+// newHTTPRequest returns the net/http request set on the responses to a raw
+// request, nil if url can't be represented
+func newHTTPRequest(method, url string, headers map[string][]string) *http.Request {
+	url, _ = unixURL(url)
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil
+	}
+	for k, v := range headers {
+		for _, vv := range v {
+			req.Header.Add(k, strings.TrimSpace(vv))
+		}
+	}
+	return req
+}
+
 func toHeaders(h map[string][]string) []client.Header {
 	var r []client.Header
 	for k, v := range h {
@@ -277,8 +457,35 @@ func fromHeaders(h []client.Header) map[string][]string {
 	return r
 }
 
+// canonicalHeaders returns h keyed by canonical header names, as net/http does
+func canonicalHeaders(h []client.Header) http.Header {
+	r := make(http.Header)
+	for _, hh := range h {
+		r.Add(hh.Key, hh.Value)
+	}
+	return r
+}
+
+// headerValue joins the values of key in headers, matched case-insensitively
+// as the keys are kept as received
 func headerValue(headers map[string][]string, key string) string {
-	return strings.Join(headers[key], " ")
+	var values []string
+	for k, v := range headers {
+		if strings.EqualFold(k, key) {
+			values = append(values, v...)
+		}
+	}
+	return strings.Join(values, " ")
+}
+
+// hasHeader reports whether headers has key, matched case-insensitively
+func hasHeader(headers map[string][]string, key string) bool {
+	for k := range headers {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return false
 }
 
 func firstErr(err1, err2 error) error {
@@ -0,0 +1,70 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/secoba/rawhttp/clientws"
+)
+
+// ErrWebSocketHandshake is returned when the server refuses the WebSocket
+// upgrade or answers it with a wrong Sec-WebSocket-Accept.
+var ErrWebSocketHandshake = errors.New("websocket handshake failed")
+
+// DoWebSocket sends a GET upgrade request on conn, adding the Connection,
+// Upgrade, Sec-WebSocket-Key and Sec-WebSocket-Version headers unless headers
+// has them, and checks the Sec-WebSocket-Accept of the 101 response against the
+// key sent unless skipAcceptCheck is set. Frames are then read and written on
+// the returned connection, to be closed by the caller. On a failed handshake the
+// response is returned with an ErrWebSocketHandshake, its body closing conn.
+func (c *Client) DoWebSocket(conn Conn, url, uripath string, headers map[string][]string, skipAcceptCheck bool) (*http.Response, *clientws.Conn, error) {
+	headers = webSocketHeaders(headers)
+	resp, stream, err := c.DoTunnel(conn, http.MethodGet, url, uripath, headers, nil)
+	if errors.Is(err, ErrTunnelRefused) {
+		return resp, nil, fmt.Errorf("%w: status %d", ErrWebSocketHandshake, resp.StatusCode)
+	}
+	if err != nil {
+		return resp, nil, err
+	}
+	if !skipAcceptCheck {
+		key := firstHeader(headers, "Sec-WebSocket-Key")
+		if accept := firstHeader(resp.Header, "Sec-WebSocket-Accept"); accept != clientws.Accept(key) {
+			stream.Close()
+			return resp, nil, fmt.Errorf("%w: unexpected Sec-WebSocket-Accept %q", ErrWebSocketHandshake, accept)
+		}
+	}
+	return resp, clientws.NewConn(stream, nil), nil
+}
+
+// webSocketHeaders returns a copy of headers with the headers of a WebSocket
+// upgrade request added when missing
+func webSocketHeaders(headers map[string][]string) map[string][]string {
+	upgrade := make(map[string][]string, len(headers)+4)
+	for k, v := range headers {
+		upgrade[k] = v
+	}
+	defaults := map[string]string{
+		"Connection":            "Upgrade",
+		"Upgrade":               "websocket",
+		"Sec-WebSocket-Key":     clientws.NewKey(),
+		"Sec-WebSocket-Version": clientws.Version,
+	}
+	for k, v := range defaults {
+		if !hasHeader(upgrade, k) {
+			upgrade[k] = []string{v}
+		}
+	}
+	return upgrade
+}
+
+// firstHeader returns the first value of key in headers, matched case-insensitively
+func firstHeader(headers map[string][]string, key string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, key) && len(v) > 0 {
+			return v[0]
+		}
+	}
+	return ""
+}
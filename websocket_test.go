@@ -0,0 +1,72 @@
+package pkg
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/secoba/rawhttp/clientws"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+func TestDoWebSocket(t *testing.T) {
+	echo := websocket.Server{Handler: func(ws *websocket.Conn) {
+		// echoes the payload of each frame in its own message
+		_, _ = io.Copy(ws, ws)
+	}}
+	server := httptest.NewServer(echo)
+	defer server.Close()
+
+	options := *DefaultOptions
+	options.Timeout = 5 * time.Second
+	c := NewClient(&options)
+
+	conn, err := c.CreateConnection(server.URL, &options)
+	require.Nil(t, err)
+	resp, ws, err := c.DoWebSocket(conn, server.URL+"/echo", "", map[string][]string{"X-Custom": {"1"}}, false)
+	require.Nil(t, err)
+	defer ws.Close()
+	require.Equal(t, 101, resp.StatusCode)
+
+	require.Nil(t, ws.WriteMessage(clientws.OpText, []byte("hello")))
+	op, data, err := ws.ReadMessage()
+	require.Nil(t, err)
+	require.Equal(t, clientws.OpText, op)
+	require.Equal(t, "hello", string(data))
+
+	require.Nil(t, ws.WriteFragments(clientws.OpText, []byte("frag"), []byte("mented")))
+	for _, fragment := range []string{"frag", "mented"} {
+		_, data, err = ws.ReadMessage()
+		require.Nil(t, err)
+		require.Equal(t, fragment, string(data))
+	}
+
+	require.Nil(t, ws.WriteMessage(clientws.OpPing, []byte("ping")))
+	f, err := ws.ReadFrame()
+	require.Nil(t, err)
+	require.Equal(t, clientws.OpPong, f.Opcode)
+	require.Equal(t, "ping", string(f.Payload))
+
+	// an accept the key does not match
+	badAccept := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Upgrade", "websocket")
+		w.Header().Set("Connection", "Upgrade")
+		w.Header().Set("Sec-WebSocket-Accept", "wrong")
+		w.WriteHeader(http.StatusSwitchingProtocols)
+	})
+	badServer := httptest.NewServer(badAccept)
+	defer badServer.Close()
+	conn, err = c.CreateConnection(badServer.URL, &options)
+	require.Nil(t, err)
+	_, _, err = c.DoWebSocket(conn, badServer.URL, "", nil, false)
+	require.True(t, errors.Is(err, ErrWebSocketHandshake))
+	conn, err = c.CreateConnection(badServer.URL, &options)
+	require.Nil(t, err)
+	_, ws, err = c.DoWebSocket(conn, badServer.URL, "", nil, true)
+	require.Nil(t, err)
+	ws.Close()
+}